@@ -1,9 +1,19 @@
 package main
 
 import (
+	"fmt"
 	"github.com/Yasuhiro-gh/url-shortener/internal/app"
+	"os"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		if err := app.Migrate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	app.Run()
 }
@@ -2,48 +2,349 @@ package app
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Yasuhiro-gh/url-shortener/internal/auth"
 	"github.com/Yasuhiro-gh/url-shortener/internal/config"
 	"github.com/Yasuhiro-gh/url-shortener/internal/db"
 	"github.com/Yasuhiro-gh/url-shortener/internal/handlers"
 	"github.com/Yasuhiro-gh/url-shortener/internal/logger"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/analytics"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/deleter"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/identity"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/reaper"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/shortcode"
 	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage/cache"
 	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage/filestore"
 	"net/http"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
 )
 
 func Run() {
 	config.Run()
 	logger.Run()
 
+	keyRing, err := auth.LoadKeyRing(config.Options.JWTKeys, config.Options.JWTKeysFile, config.Options.JWTActiveKID)
+	if err != nil {
+		panic(err)
+	}
+	auth.SetKeyRing(keyRing)
+	if config.Options.JWTKeys == "" && config.Options.JWTKeysFile == "" {
+		logger.Infoln("auth", "warning", "no jwt keys configured, using a random key")
+	}
+
+	trustedProxies, err := analytics.ParseTrustedProxies(config.Options.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+	analytics.SetTrustedProxies(trustedProxies)
+
+	ipSalt := []byte(config.Options.IPHashSalt)
+	if len(ipSalt) == 0 {
+		ipSalt, err = analytics.GenerateIPSalt()
+		if err != nil {
+			panic(err)
+		}
+		logger.Infoln("analytics", "warning", "no ip salt configured, unique visitors are counted per run")
+	}
+	analytics.SetIPSalt(ipSalt)
+
+	if err := shortcode.ValidateAlphabet(config.Options.ShortCodeAlphabet); err != nil {
+		panic(err)
+	}
+	if !handlers.IsRedirectType(config.Options.RedirectType) {
+		panic(fmt.Errorf("unsupported redirect type %d", config.Options.RedirectType))
+	}
+
 	pdb := db.NewPostgresDB()
+	var pinger db.Pinger = pdb
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	var urls *storage.URLS
-	if config.Options.DatabaseDSN != "" {
+	var clickStorage storage.ClickStorages
+	var users identity.UserIDAllocator
+	var codeNumbers codeSequence
+	var fileStorage *filestore.FileStorage
+	var urlCache *cache.Cache
+	if config.Options.StorageURL != "" {
+		path, ok := db.ParseSQLiteURL(config.Options.StorageURL)
+		if !ok {
+			panic(fmt.Errorf("unsupported storage url %q", config.Options.StorageURL))
+		}
+		sdb := db.NewSQLiteDB()
+		err := sdb.OpenConnection(path)
+		if err != nil {
+			panic(err)
+		}
+		err = db.NewSQLiteMigrator(sdb).Up(ctx)
+		if err != nil {
+			panic(err)
+		}
+		defer sdb.CloseConnection()
+		urlCache = cache.NewCache(sdb, config.Options.CacheSize, config.Options.CacheTTL)
+		urls = storage.NewURLS(urlCache)
+		clickStorage = sdb
+		users = sdb
+		codeNumbers = sdb
+		pinger = sdb
+	} else if config.Options.DatabaseDSN != "" {
 		err := pdb.OpenConnection()
 		if err != nil {
 			panic(err)
 		}
-		err = db.CreateDatabaseTable(pdb)
+		err = db.NewPostgresMigrator(pdb).Up(ctx)
 		if err != nil {
 			panic(err)
 		}
 		defer pdb.CloseConnection()
-		urls = storage.NewURLS(pdb)
+		urlCache = cache.NewCache(pdb, config.Options.CacheSize, config.Options.CacheTTL)
+		urls = storage.NewURLS(urlCache)
+		clickStorage = pdb
+		users = pdb
+		codeNumbers = pdb
+	} else if config.Options.FileStoragePath != "" {
+		fileStorage = filestore.NewFileStorage(storage.NewURLStorage(), config.Options.FileStoragePath,
+			config.Options.FileCompactThreshold, config.Options.FileSyncPolicy, config.Options.FileSyncInterval)
+		err = fileStorage.Restore()
+		if err != nil {
+			panic(err)
+		}
+		urls = storage.NewURLS(fileStorage)
+		clickStorage = fileStorage
 	} else {
-		us := storage.NewURLStorage()
-		urls = storage.NewURLS(us)
+		urlStorage := storage.NewURLStorage()
+		urls = storage.NewURLS(urlStorage)
+		clickStorage = storage.NewCountingClickStorage(urlStorage)
+	}
+
+	if users == nil {
+		lastUserID, err := urls.GetUserID(ctx)
+		if err != nil {
+			panic(err)
+		}
+		users, err = identity.NewCounterAllocator(filestore.UserIDCounterPath(), lastUserID)
+		if err != nil {
+			panic(err)
+		}
 	}
 
-	err := filestore.Restore(urls)
+	var seq shortcode.Sequence = codeNumbers
+	if config.Options.ShortCodeGenerator == shortcode.CounterGeneratorType {
+		seq, err = newCodeSequence(ctx, codeNumbers, fileStorage)
+		if err != nil {
+			panic(err)
+		}
+	}
+	gen, err := shortcode.NewGenerator(config.Options.ShortCodeGenerator, config.Options.ShortCodeLength, config.Options.ShortCodeAlphabet, seq)
 	if err != nil {
 		panic(err)
 	}
 
-	err = http.ListenAndServe(config.Options.Addr, handlers.URLRouter(ctx, urls, pdb))
+	var workers sync.WaitGroup
+	runWorker := func(run func(context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(ctx)
+		}()
+	}
+
+	runWorker(reaper.NewReaper(urls, config.Options.ReaperInterval, config.Options.ReaperBatchSize, config.Options.TrashRetention).Run)
+
+	clicks := analytics.NewPipeline(clickStorage, config.Options.ClicksBufferSize, config.Options.ClicksBatchSize, config.Options.ClicksFlushInterval)
+	runWorker(clicks.Run)
+
+	del := deleter.NewDeleter(urls, config.Options.DeleteQueueSize, config.Options.DeleteBatchSize, config.Options.DeleteFlushInterval)
+	runWorker(del.Run)
+
+	router := handlers.URLRouter(ctx, urls, pinger, gen, clicks, users, del)
+
+	server := &http.Server{
+		Addr:         config.Options.Addr,
+		Handler:      router,
+		ReadTimeout:  config.Options.ReadTimeout,
+		WriteTimeout: config.Options.WriteTimeout,
+		IdleTimeout:  config.Options.IdleTimeout,
+	}
+
+	// Debug endpoints are served on their own listener, which is meant to be
+	// reachable from the internal network only.
+	var debugServer *http.Server
+	if config.Options.DebugAddr != "" {
+		debugRouter := http.NewServeMux()
+		if urlCache != nil {
+			debugRouter.Handle("GET /debug/cache", logger.Logging(handlers.CacheStats(urlCache)))
+		}
+		debugServer = &http.Server{
+			Addr:         config.Options.DebugAddr,
+			Handler:      debugRouter,
+			ReadTimeout:  config.Options.ReadTimeout,
+			WriteTimeout: config.Options.WriteTimeout,
+			IdleTimeout:  config.Options.IdleTimeout,
+		}
+	}
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 2)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	if debugServer != nil {
+		go func() {
+			serveErr <- debugServer.ListenAndServe()
+		}()
+	}
+
+	var fatalErr error
+	select {
+	case err := <-serveErr:
+		fatalErr = err
+	case <-sigCtx.Done():
+		logger.Infoln("shutdown", "signal received")
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), config.Options.ShutdownTimeout)
+	defer cancelShutdown()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Errorln("shutdown", "http server", err)
+	}
+	if debugServer != nil {
+		if err := debugServer.Shutdown(shutdownCtx); err != nil {
+			logger.Errorln("shutdown", "debug server", err)
+		}
+	}
+
+	cancel()
+	workersDone := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-shutdownCtx.Done():
+		logger.Errorln("shutdown", "background workers did not finish in time")
+	}
+
+	if urlCache != nil {
+		stats := urlCache.Stats()
+		logger.Infoln("cache", "hits", stats.Hits, "misses", stats.Misses, "entries", stats.Entries)
+	}
+	if fileStorage != nil {
+		if err := fileStorage.Close(); err != nil {
+			logger.Errorln("shutdown", "file storage", err)
+		}
+	}
+
+	if fatalErr != nil && !errors.Is(fatalErr, http.ErrServerClosed) {
+		panic(fatalErr)
+	}
+	logger.Infoln("shutdown", "completed")
+}
+
+func Migrate() error {
+	config.Run()
+	logger.Run()
+
+	args := flag.Args()
+
+	var migrator *db.Migrator
+	switch {
+	case config.Options.StorageURL != "":
+		path, ok := db.ParseSQLiteURL(config.Options.StorageURL)
+		if !ok {
+			return fmt.Errorf("migrate: unsupported storage url %q", config.Options.StorageURL)
+		}
+		sdb := db.NewSQLiteDB()
+		if err := sdb.OpenConnection(path); err != nil {
+			return err
+		}
+		defer sdb.CloseConnection()
+		migrator = db.NewSQLiteMigrator(sdb)
+	case config.Options.DatabaseDSN != "":
+		pdb := db.NewPostgresDB()
+		if err := pdb.OpenConnection(); err != nil {
+			return err
+		}
+		defer pdb.CloseConnection()
+		migrator = db.NewPostgresMigrator(pdb)
+	default:
+		return errors.New("migrate: neither database dsn nor storage url is set")
+	}
+
+	ctx := context.Background()
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("migrate: bad number of steps %q", args[1])
+			}
+			steps = n
+		}
+		return migrator.Down(ctx, steps)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			state := "pending"
+			if st.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%s\t%s\n", st.Version, st.Name, state)
+		}
+		return nil
+	}
+	return fmt.Errorf("migrate: unknown command %q, expected up, down or status", command)
+}
+
+type codeSequence interface {
+	shortcode.Sequence
+	SeedCodeNumbers(ctx context.Context, decode func(code string) (int, bool)) error
+}
+
+// newCodeSequence returns the sequence of the counter generator, moved past
+// every stored code. Without a database it is a counter persisted next to the
+// file storage.
+func newCodeSequence(ctx context.Context, codeNumbers codeSequence, fileStorage *filestore.FileStorage) (shortcode.Sequence, error) {
+	decode := func(code string) (int, bool) {
+		return shortcode.DecodeCounter(config.Options.ShortCodeAlphabet, code)
+	}
+	if codeNumbers != nil {
+		return codeNumbers, codeNumbers.SeedCodeNumbers(ctx, decode)
+	}
+
+	lastCode := 0
+	if fileStorage != nil {
+		fileStorage.Range(func(code string, _ storage.Store) bool {
+			if n, ok := decode(code); ok && n > lastCode {
+				lastCode = n
+			}
+			return true
+		})
+	}
+	counter, err := identity.NewCounterAllocator(filestore.CodeCounterPath(), lastCode)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return shortcode.SequenceFunc(counter.Next), nil
 }
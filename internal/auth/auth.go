@@ -1,38 +1,161 @@
+// Package auth issues and validates the userIDToken JWT.
+//
+// Tokens are signed with HS256 by the active key of the key ring and carry its
+// id in the "kid" header, so tokens signed by any key still present in the ring
+// stay valid. Keys are configured as "kid:secret" pairs via -jwt-keys/JWT_KEYS
+// or a file with one pair per line via -jwt-keys-file/JWT_KEYS_FILE, and the
+// signing key is selected with -jwt-kid/JWT_ACTIVE_KID.
+//
+// Rotation procedure:
+//  1. Add the new key next to the current one and keep the current one active.
+//     Roll this out to every replica so all of them can validate the new key.
+//  2. Make the new key active. New tokens are signed with it, tokens signed
+//     with the retiring key are still accepted.
+//  3. After TOKENEXP has passed, remove the retiring key.
+//
+// Without configured keys a random key is generated on startup, so tokens do
+// not survive a restart.
 package auth
 
 import (
+	"bufio"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v4"
+	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
 const TOKENEXP = time.Minute * 10
-const SECRETKEY = "yasuhiro_gh"
+
+const generatedKID = "generated"
 
 type Claims struct {
 	jwt.RegisteredClaims
 	UserID int
 }
 
+type KeyRing struct {
+	activeKID string
+	keys      map[string][]byte
+}
+
+var (
+	keyRingMu sync.RWMutex
+	keyRing   = mustGenerateKeyRing()
+)
+
+func NewKeyRing(activeKID string, keys map[string][]byte) (*KeyRing, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys configured")
+	}
+	if activeKID == "" && len(keys) == 1 {
+		for kid := range keys {
+			activeKID = kid
+		}
+	}
+	if _, ok := keys[activeKID]; !ok {
+		return nil, fmt.Errorf("active signing key %q is not configured", activeKID)
+	}
+	return &KeyRing{activeKID: activeKID, keys: keys}, nil
+}
+
+func GenerateKeyRing() (*KeyRing, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return NewKeyRing(generatedKID, map[string][]byte{generatedKID: secret})
+}
+
+func mustGenerateKeyRing() *KeyRing {
+	kr, err := GenerateKeyRing()
+	if err != nil {
+		panic(err)
+	}
+	return kr
+}
+
+// ParseKeys parses "kid:secret" pairs separated by commas or new lines.
+func ParseKeys(spec string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	scn := bufio.NewScanner(strings.NewReader(strings.ReplaceAll(spec, ",", "\n")))
+	for scn.Scan() {
+		line := strings.TrimSpace(scn.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kid, secret, ok := strings.Cut(line, ":")
+		if !ok || kid == "" || secret == "" {
+			return nil, errors.New("signing keys must be given as kid:secret")
+		}
+		if _, exist := keys[kid]; exist {
+			return nil, fmt.Errorf("duplicate signing key %q", kid)
+		}
+		keys[kid] = []byte(secret)
+	}
+	return keys, scn.Err()
+}
+
+func LoadKeyRing(keysSpec string, keysFile string, activeKID string) (*KeyRing, error) {
+	if keysFile != "" {
+		data, err := os.ReadFile(keysFile)
+		if err != nil {
+			return nil, err
+		}
+		keysSpec += "\n" + string(data)
+	}
+	if strings.TrimSpace(keysSpec) == "" {
+		return GenerateKeyRing()
+	}
+	keys, err := ParseKeys(keysSpec)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyRing(activeKID, keys)
+}
+
+func SetKeyRing(kr *KeyRing) {
+	keyRingMu.Lock()
+	defer keyRingMu.Unlock()
+	keyRing = kr
+}
+
+func currentKeyRing() *KeyRing {
+	keyRingMu.RLock()
+	defer keyRingMu.RUnlock()
+	return keyRing
+}
+
 func BuildJWTString(newUserID int) (string, error) {
+	kr := currentKeyRing()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TOKENEXP)),
 		},
 		UserID: newUserID,
 	})
-	return token.SignedString([]byte(SECRETKEY))
+	token.Header["kid"] = kr.activeKID
+	return token.SignedString(kr.keys[kr.activeKID])
 }
 
 func GetUserID(tokenString string) (int, error) {
 	claims := &Claims{}
+	kr := currentKeyRing()
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 		}
-		return []byte(SECRETKEY), nil
+		kid, _ := t.Header["kid"].(string)
+		key, ok := kr.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %q", kid)
+		}
+		return key, nil
 	})
 
 	if err != nil {
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyRotation(t *testing.T) {
+	defer SetKeyRing(currentKeyRing())
+
+	oldRing, err := LoadKeyRing("old:old-secret", "", "old")
+	require.NoError(t, err)
+	SetKeyRing(oldRing)
+
+	oldToken, err := BuildJWTString(42)
+	require.NoError(t, err)
+
+	// Step 1: the new key is known to every replica, the old one still signs.
+	ring, err := LoadKeyRing("old:old-secret,new:new-secret", "", "old")
+	require.NoError(t, err)
+	SetKeyRing(ring)
+	uid, err := GetUserID(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, 42, uid)
+
+	// Step 2: the new key signs, tokens signed with the retiring key still work.
+	ring, err = LoadKeyRing("old:old-secret,new:new-secret", "", "new")
+	require.NoError(t, err)
+	SetKeyRing(ring)
+	newToken, err := BuildJWTString(7)
+	require.NoError(t, err)
+	uid, err = GetUserID(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, 42, uid)
+	uid, err = GetUserID(newToken)
+	require.NoError(t, err)
+	assert.Equal(t, 7, uid)
+
+	// Step 3: the retiring key is removed.
+	ring, err = LoadKeyRing("new:new-secret", "", "new")
+	require.NoError(t, err)
+	SetKeyRing(ring)
+	_, err = GetUserID(oldToken)
+	assert.Error(t, err)
+	uid, err = GetUserID(newToken)
+	require.NoError(t, err)
+	assert.Equal(t, 7, uid)
+}
+
+func TestForgedToken(t *testing.T) {
+	defer SetKeyRing(currentKeyRing())
+
+	forger, err := LoadKeyRing("main:yasuhiro_gh", "", "")
+	require.NoError(t, err)
+	SetKeyRing(forger)
+	forged, err := BuildJWTString(1)
+	require.NoError(t, err)
+
+	ring, err := LoadKeyRing("main:real-secret", "", "")
+	require.NoError(t, err)
+	SetKeyRing(ring)
+	_, err = GetUserID(forged)
+	assert.Error(t, err)
+}
+
+func TestLoadKeyRing(t *testing.T) {
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(keysFile, []byte("# rotated 2026-10\nk2:second\n"), 0600))
+
+	tests := []struct {
+		name      string
+		keys      string
+		keysFile  string
+		activeKID string
+		wantErr   bool
+	}{
+		{name: "generated", keys: ""},
+		{name: "single key without kid", keys: "k1:first"},
+		{name: "keys and file", keys: "k1:first", keysFile: keysFile, activeKID: "k2"},
+		{name: "unknown active kid", keys: "k1:first", activeKID: "k3", wantErr: true},
+		{name: "ambiguous active kid", keys: "k1:first,k2:second", wantErr: true},
+		{name: "malformed", keys: "first", wantErr: true},
+		{name: "duplicate kid", keys: "k1:first,k1:second", activeKID: "k1", wantErr: true},
+		{name: "missing file", keysFile: keysFile + ".missing", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := LoadKeyRing(test.keys, test.keysFile, test.activeKID)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
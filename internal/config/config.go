@@ -2,14 +2,52 @@ package config
 
 import (
 	"flag"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/shortcode"
 	"os"
+	"strconv"
+	"time"
 )
 
 var Options struct {
-	Addr            string
-	BaseURL         string
-	FileStoragePath string
-	DatabaseDSN     string
+	Addr                 string
+	BaseURL              string
+	FileStoragePath      string
+	DatabaseDSN          string
+	StorageURL           string
+	FileCompactThreshold int
+	FileSyncPolicy       string
+	FileSyncInterval     time.Duration
+	ShortCodeGenerator   string
+	ShortCodeLength      int
+	ShortCodeAlphabet    string
+	ShortCodeMaxAttempts int
+	ReaperInterval       time.Duration
+	ReaperBatchSize      int
+	TrashRetention       time.Duration
+	ClicksBufferSize     int
+	ClicksBatchSize      int
+	ClicksFlushInterval  time.Duration
+	DeleteQueueSize      int
+	DeleteBatchSize      int
+	DeleteFlushInterval  time.Duration
+	BulkMaxLines         int
+	BulkChunkSize        int
+	ReadTimeout          time.Duration
+	WriteTimeout         time.Duration
+	IdleTimeout          time.Duration
+	ShutdownTimeout      time.Duration
+	DebugAddr            string
+	CacheSize            int
+	CacheTTL             time.Duration
+	RedirectType         int
+	RedirectMaxAge       time.Duration
+	PasswordMaxAttempts  int
+	PasswordWindow       time.Duration
+	TrustedProxies       string
+	IPHashSalt           string
+	JWTKeys              string
+	JWTKeysFile          string
+	JWTActiveKID         string
 }
 
 func Run() {
@@ -17,6 +55,42 @@ func Run() {
 	flag.StringVar(&Options.BaseURL, "b", "http://localhost:8080", "base url")
 	flag.StringVar(&Options.FileStoragePath, "f", "temp", "file storage path")
 	flag.StringVar(&Options.DatabaseDSN, "d", "", "database dsn")
+	flag.StringVar(&Options.StorageURL, "s", "", "storage url, e.g. sqlite:///var/lib/shortener.db")
+	flag.StringVar(&Options.FileSyncPolicy, "fsync", "always", "file storage fsync policy: always, interval or never")
+	flag.DurationVar(&Options.FileSyncInterval, "fsync-interval", time.Second, "file storage fsync interval for the interval policy")
+	flag.IntVar(&Options.FileCompactThreshold, "compact-threshold", 10000, "file storage records count that triggers compaction")
+	flag.StringVar(&Options.ShortCodeGenerator, "g", "hash", "short code generator: hash, counter or random")
+	flag.IntVar(&Options.ShortCodeLength, "l", 8, "short code length for hash and random generators")
+	flag.StringVar(&Options.ShortCodeAlphabet, "alphabet", shortcode.Base62Alphabet, "short code alphabet for counter and random generators")
+	flag.IntVar(&Options.ShortCodeMaxAttempts, "max-attempts", 10, "max short code generation attempts on collision")
+
+	flag.DurationVar(&Options.ReaperInterval, "reaper-interval", time.Minute, "expired links reaper interval")
+	flag.IntVar(&Options.ReaperBatchSize, "reaper-batch", 1000, "expired links reaper batch size")
+	flag.DurationVar(&Options.TrashRetention, "trash-retention", 30*24*time.Hour, "how long deleted links can be restored before they are purged, 0 keeps them forever")
+	flag.IntVar(&Options.ClicksBufferSize, "clicks-buffer", 10000, "click events buffer size")
+	flag.IntVar(&Options.ClicksBatchSize, "clicks-batch", 500, "click events write batch size")
+	flag.DurationVar(&Options.ClicksFlushInterval, "clicks-flush", time.Second, "click events flush interval")
+	flag.IntVar(&Options.DeleteQueueSize, "delete-queue", 1000, "delete jobs queue size")
+	flag.IntVar(&Options.DeleteBatchSize, "delete-batch", 1000, "delete batch size")
+	flag.DurationVar(&Options.DeleteFlushInterval, "delete-flush", time.Second, "delete batch flush interval")
+	flag.IntVar(&Options.BulkMaxLines, "bulk-max", 1000000, "max urls accepted by one bulk import")
+	flag.IntVar(&Options.BulkChunkSize, "bulk-chunk", 1000, "urls stored per bulk import chunk")
+	flag.DurationVar(&Options.ReadTimeout, "read-timeout", 10*time.Second, "http server read timeout")
+	flag.DurationVar(&Options.WriteTimeout, "write-timeout", 30*time.Second, "http server write timeout")
+	flag.DurationVar(&Options.IdleTimeout, "idle-timeout", 2*time.Minute, "http server idle timeout")
+	flag.DurationVar(&Options.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "graceful shutdown deadline")
+	flag.StringVar(&Options.DebugAddr, "debug-addr", "", "internal http address serving debug endpoints such as cache stats, empty disables it")
+	flag.IntVar(&Options.CacheSize, "cache-size", 10000, "max short urls cached in front of the database, 0 disables the cache")
+	flag.DurationVar(&Options.CacheTTL, "cache-ttl", time.Minute, "how long a cached short url lookup stays valid")
+	flag.IntVar(&Options.RedirectType, "redirect-type", 307, "redirect status for links without their own: 301, 302, 307 or 308")
+	flag.DurationVar(&Options.RedirectMaxAge, "redirect-max-age", 24*time.Hour, "how long clients may cache permanent redirects")
+	flag.IntVar(&Options.PasswordMaxAttempts, "password-attempts", 5, "password guesses allowed per client and link within the password window, 0 disables the limit")
+	flag.DurationVar(&Options.PasswordWindow, "password-window", 15*time.Minute, "window of the password guesses limit")
+	flag.StringVar(&Options.TrustedProxies, "trusted-proxies", "", "comma separated proxy ips and cidrs whose X-Forwarded-For is trusted for password guess limits and unique visitors")
+	flag.StringVar(&Options.IPHashSalt, "ip-salt", "", "secret mixed into the visitor ip hashes of click analytics")
+	flag.StringVar(&Options.JWTKeys, "jwt-keys", "", "jwt signing keys as comma separated kid:secret pairs")
+	flag.StringVar(&Options.JWTKeysFile, "jwt-keys-file", "", "file with jwt signing keys, one kid:secret pair per line")
+	flag.StringVar(&Options.JWTActiveKID, "jwt-kid", "", "id of the jwt key used for signing new tokens")
 
 	flag.Parse()
 
@@ -32,4 +106,109 @@ func Run() {
 	if databaseDSN := os.Getenv("DATABASE_DSN"); databaseDSN != "" {
 		Options.DatabaseDSN = databaseDSN
 	}
+	if storageURL := os.Getenv("STORAGE_URL"); storageURL != "" {
+		Options.StorageURL = storageURL
+	}
+	if syncPolicy := os.Getenv("FILE_SYNC_POLICY"); syncPolicy != "" {
+		Options.FileSyncPolicy = syncPolicy
+	}
+	if interval, err := time.ParseDuration(os.Getenv("FILE_SYNC_INTERVAL")); err == nil {
+		Options.FileSyncInterval = interval
+	}
+	if threshold, err := strconv.Atoi(os.Getenv("FILE_COMPACT_THRESHOLD")); err == nil {
+		Options.FileCompactThreshold = threshold
+	}
+	if generator := os.Getenv("SHORT_CODE_GENERATOR"); generator != "" {
+		Options.ShortCodeGenerator = generator
+	}
+	if length, err := strconv.Atoi(os.Getenv("SHORT_CODE_LENGTH")); err == nil {
+		Options.ShortCodeLength = length
+	}
+	if alphabet := os.Getenv("SHORT_CODE_ALPHABET"); alphabet != "" {
+		Options.ShortCodeAlphabet = alphabet
+	}
+	if attempts, err := strconv.Atoi(os.Getenv("SHORT_CODE_MAX_ATTEMPTS")); err == nil {
+		Options.ShortCodeMaxAttempts = attempts
+	}
+	if interval, err := time.ParseDuration(os.Getenv("REAPER_INTERVAL")); err == nil {
+		Options.ReaperInterval = interval
+	}
+	if batchSize, err := strconv.Atoi(os.Getenv("REAPER_BATCH_SIZE")); err == nil {
+		Options.ReaperBatchSize = batchSize
+	}
+	if retention, err := time.ParseDuration(os.Getenv("TRASH_RETENTION")); err == nil {
+		Options.TrashRetention = retention
+	}
+	if bufferSize, err := strconv.Atoi(os.Getenv("CLICKS_BUFFER_SIZE")); err == nil {
+		Options.ClicksBufferSize = bufferSize
+	}
+	if batchSize, err := strconv.Atoi(os.Getenv("CLICKS_BATCH_SIZE")); err == nil {
+		Options.ClicksBatchSize = batchSize
+	}
+	if interval, err := time.ParseDuration(os.Getenv("CLICKS_FLUSH_INTERVAL")); err == nil {
+		Options.ClicksFlushInterval = interval
+	}
+	if queueSize, err := strconv.Atoi(os.Getenv("DELETE_QUEUE_SIZE")); err == nil {
+		Options.DeleteQueueSize = queueSize
+	}
+	if batchSize, err := strconv.Atoi(os.Getenv("DELETE_BATCH_SIZE")); err == nil {
+		Options.DeleteBatchSize = batchSize
+	}
+	if interval, err := time.ParseDuration(os.Getenv("DELETE_FLUSH_INTERVAL")); err == nil {
+		Options.DeleteFlushInterval = interval
+	}
+	if maxLines, err := strconv.Atoi(os.Getenv("BULK_MAX_LINES")); err == nil {
+		Options.BulkMaxLines = maxLines
+	}
+	if chunkSize, err := strconv.Atoi(os.Getenv("BULK_CHUNK_SIZE")); err == nil {
+		Options.BulkChunkSize = chunkSize
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("READ_TIMEOUT")); err == nil {
+		Options.ReadTimeout = timeout
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("WRITE_TIMEOUT")); err == nil {
+		Options.WriteTimeout = timeout
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("IDLE_TIMEOUT")); err == nil {
+		Options.IdleTimeout = timeout
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil {
+		Options.ShutdownTimeout = timeout
+	}
+	if size, err := strconv.Atoi(os.Getenv("CACHE_SIZE")); err == nil {
+		Options.CacheSize = size
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("CACHE_TTL")); err == nil {
+		Options.CacheTTL = ttl
+	}
+	if redirectType, err := strconv.Atoi(os.Getenv("REDIRECT_TYPE")); err == nil {
+		Options.RedirectType = redirectType
+	}
+	if maxAge, err := time.ParseDuration(os.Getenv("REDIRECT_MAX_AGE")); err == nil {
+		Options.RedirectMaxAge = maxAge
+	}
+	if attempts, err := strconv.Atoi(os.Getenv("PASSWORD_MAX_ATTEMPTS")); err == nil {
+		Options.PasswordMaxAttempts = attempts
+	}
+	if window, err := time.ParseDuration(os.Getenv("PASSWORD_WINDOW")); err == nil {
+		Options.PasswordWindow = window
+	}
+	if debugAddr := os.Getenv("DEBUG_ADDRESS"); debugAddr != "" {
+		Options.DebugAddr = debugAddr
+	}
+	if trustedProxies := os.Getenv("TRUSTED_PROXIES"); trustedProxies != "" {
+		Options.TrustedProxies = trustedProxies
+	}
+	if ipHashSalt := os.Getenv("IP_HASH_SALT"); ipHashSalt != "" {
+		Options.IPHashSalt = ipHashSalt
+	}
+	if jwtKeys := os.Getenv("JWT_KEYS"); jwtKeys != "" {
+		Options.JWTKeys = jwtKeys
+	}
+	if jwtKeysFile := os.Getenv("JWT_KEYS_FILE"); jwtKeysFile != "" {
+		Options.JWTKeysFile = jwtKeysFile
+	}
+	if jwtActiveKID := os.Getenv("JWT_ACTIVE_KID"); jwtActiveKID != "" {
+		Options.JWTActiveKID = jwtActiveKID
+	}
 }
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// batchChunkSize keeps every statement well below the bind parameter limits
+// of both Postgres and SQLite.
+const batchChunkSize = 500
+
+// batchRetries bounds how often a batch is rechecked after a concurrent
+// writer inserted one of its URLs between the checks and the insert.
+const batchRetries = 3
+
+// dialect is what the shared SQL code needs to know about a backend.
+type dialect struct {
+	bind              func(n int) string
+	nullTime          func(t time.Time) sql.NullTime
+	isUniqueViolation func(err error) bool
+	// lockRow is appended to selects of rows that are about to be updated.
+	lockRow string
+}
+
+var postgresDialect = dialect{
+	bind:              func(n int) string { return "$" + strconv.Itoa(n) },
+	nullTime:          toNullTime,
+	isUniqueViolation: isUniqueViolation,
+	lockRow:           " FOR UPDATE",
+}
+
+var sqliteDialect = dialect{
+	bind:              func(int) string { return "?" },
+	nullTime:          toNullUTCTime,
+	isUniqueViolation: isSQLiteConstraintError,
+}
+
+func (d dialect) placeholders(first, n int) string {
+	binds := make([]string, n)
+	for i := range binds {
+		binds[i] = d.bind(first + i)
+	}
+	return strings.Join(binds, ", ")
+}
+
+type userURL struct {
+	userID      int
+	originalURL string
+}
+
+// setBatch stores the items in one transaction with multi-row inserts. If
+// any code collides the transaction is rolled back and nothing is stored.
+func setBatch(ctx context.Context, db *sql.DB, d dialect, items []storage.BatchItem) error {
+	unique, duplicateOf, collided := storage.SplitBatch(items)
+	for attempt := 0; ; attempt++ {
+		err := trySetBatch(ctx, db, d, items, unique, collided)
+		if err != nil && d.isUniqueViolation(err) && attempt < batchRetries {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		storage.ResolveDuplicates(items, duplicateOf)
+		return nil
+	}
+}
+
+// trySetBatch stores nothing if collided, which reports code collisions
+// within the batch, or if a code belongs to another stored URL.
+func trySetBatch(ctx context.Context, db *sql.DB, d dialect, items []storage.BatchItem, unique []int, collided bool) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	codes := make([]any, len(unique))
+	for n, i := range unique {
+		items[i].Err = nil
+		codes[n] = items[i].ShortURL
+	}
+	byCode := make(map[string]storage.Store, len(unique))
+	err = queryChunks(codes, func(chunk []any) error {
+		return queryStores(ctx, tx, "SELECT short_url, original_url, user_id, is_deleted, expires_at FROM urls WHERE short_url IN ("+
+			d.placeholders(1, len(chunk))+")", chunk, func(existing storage.Store) {
+			byCode[existing.ShortURL] = existing
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	urlsByUser := make(map[int][]any)
+	for _, i := range unique {
+		item := &items[i]
+		existing, ok := byCode[item.ShortURL]
+		switch {
+		case ok && (existing.OriginalURL != item.Store.OriginalURL || existing.UserID != item.Store.UserID):
+			item.Err = storage.ErrCodeCollision
+			collided = true
+		case ok:
+			item.Err = &storage.ConflictError{Existing: existing}
+		default:
+			urlsByUser[item.Store.UserID] = append(urlsByUser[item.Store.UserID], item.Store.OriginalURL)
+		}
+	}
+	if collided {
+		return nil
+	}
+
+	byURL := make(map[userURL]storage.Store)
+	for userID, urls := range urlsByUser {
+		err = queryChunks(urls, func(chunk []any) error {
+			return queryStores(ctx, tx, "SELECT short_url, original_url, user_id, is_deleted, expires_at FROM urls WHERE user_id = "+
+				d.bind(1)+" AND original_url IN ("+d.placeholders(2, len(chunk))+")", append([]any{userID}, chunk...), func(existing storage.Store) {
+				byURL[userURL{existing.UserID, existing.OriginalURL}] = existing
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var rows []any
+	now := time.Now().UTC()
+	for _, i := range unique {
+		item := &items[i]
+		if item.Err != nil {
+			continue
+		}
+		if existing, ok := byURL[userURL{item.Store.UserID, item.Store.OriginalURL}]; ok {
+			item.Err = &storage.ConflictError{Existing: existing}
+			continue
+		}
+		if item.Store.CreatedAt.IsZero() {
+			item.Store.CreatedAt = now
+		}
+		rows = append(rows, item.ShortURL, item.Store.OriginalURL, item.Store.UserID, d.nullTime(item.Store.ExpiresAt), d.nullTime(item.Store.CreatedAt),
+			item.Store.RedirectType, item.Store.ForwardQuery, item.Store.ForwardPath, item.Store.PasswordHash, item.Store.MaxClicks)
+	}
+
+	const columns = 10
+	for len(rows) > 0 {
+		n := min(len(rows), batchChunkSize*columns)
+		values := make([]string, n/columns)
+		for r := range values {
+			values[r] = "(" + d.placeholders(r*columns+1, columns) + ")"
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO urls (short_url, original_url, user_id, expires_at, created_at, redirect_type, forward_query, forward_path, password_hash, max_clicks) VALUES "+strings.Join(values, ", "), rows[:n]...)
+		if err != nil {
+			return err
+		}
+		rows = rows[n:]
+	}
+	return tx.Commit()
+}
+
+func queryChunks(args []any, query func(chunk []any) error) error {
+	for len(args) > 0 {
+		n := min(len(args), batchChunkSize)
+		if err := query(args[:n]); err != nil {
+			return err
+		}
+		args = args[n:]
+	}
+	return nil
+}
+
+func queryStores(ctx context.Context, tx *sql.Tx, query string, args []any, fn func(storage.Store)) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var store storage.Store
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&store.ShortURL, &store.OriginalURL, &store.UserID, &store.DeletedFlag, &expiresAt); err != nil {
+			return err
+		}
+		store.ExpiresAt = expiresAt.Time
+		fn(store)
+	}
+	return rows.Err()
+}
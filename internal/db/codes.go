@@ -0,0 +1,26 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// maxCodeNumber is the largest number decode finds among the stored codes.
+func maxCodeNumber(ctx context.Context, db *sql.DB, decode func(code string) (int, bool)) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT short_url FROM urls")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	last := 0
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return 0, err
+		}
+		if n, ok := decode(code); ok && n > last {
+			last = n
+		}
+	}
+	return last, rows.Err()
+}
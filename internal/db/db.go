@@ -7,10 +7,15 @@ import (
 	"github.com/Yasuhiro-gh/url-shortener/internal/config"
 	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
-	"strings"
+	"time"
 )
 
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
 type PostgresDB struct {
 	DB *sql.DB
 }
@@ -19,85 +24,217 @@ func NewPostgresDB() *PostgresDB {
 	return &PostgresDB{}
 }
 
-func (pdb *PostgresDB) Get(shortURL string) (storage.Store, bool) {
-	qr := pdb.DB.QueryRow("SELECT original_url, user_id, is_deleted FROM urls WHERE short_url = $1", shortURL)
-	if qr.Err() != nil {
-		return storage.Store{}, false
-	}
+func (pdb *PostgresDB) Get(ctx context.Context, shortURL string) (storage.Store, error) {
 	var store storage.Store
-	err := qr.Scan(&store.OriginalURL, &store.UserID, &store.DeletedFlag)
+	var expiresAt, createdAt, deletedAt sql.NullTime
+	err := pdb.DB.QueryRowContext(ctx, "SELECT original_url, user_id, is_deleted, expires_at, created_at, click_count, deleted_at, redirect_type, forward_query, forward_path, password_hash, max_clicks, used_clicks FROM urls WHERE short_url = $1", shortURL).
+		Scan(&store.OriginalURL, &store.UserID, &store.DeletedFlag, &expiresAt, &createdAt, &store.Clicks, &deletedAt, &store.RedirectType, &store.ForwardQuery, &store.ForwardPath, &store.PasswordHash, &store.MaxClicks, &store.UsedClicks)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Store{}, storage.ErrNotFound
+	}
 	if err != nil {
-		return storage.Store{}, false
+		return storage.Store{}, err
+	}
+	store.ExpiresAt, store.CreatedAt, store.DeletedAt = expiresAt.Time, createdAt.Time, deletedAt.Time
+	if store.DeletedFlag {
+		return store, storage.ErrDeleted
 	}
-	return store, true
+	return store, nil
 }
 
-func (pdb *PostgresDB) Set(shortURL string, store *storage.Store) error {
-	_, err := pdb.DB.Exec("INSERT INTO urls (short_url, original_url, user_id) VALUES ($1, $2, $3)",
-		shortURL, store.OriginalURL, store.UserID)
-	if err != nil && strings.Contains(err.Error(), pgerrcode.UniqueViolation) {
-		return errors.New(pgerrcode.UniqueViolation)
+func (pdb *PostgresDB) UseClick(ctx context.Context, shortURL string) (int64, error) {
+	return useClick(ctx, pdb.DB, postgresDialect, shortURL)
+}
+
+func (pdb *PostgresDB) Set(ctx context.Context, shortURL string, store *storage.Store) error {
+	if taken, err := pdb.isCodeTaken(ctx, shortURL, store); err != nil || taken {
+		if err == nil {
+			err = storage.ErrCodeCollision
+		}
+		return err
+	}
+	if store.CreatedAt.IsZero() {
+		store.CreatedAt = time.Now().UTC()
+	}
+	_, err := pdb.DB.ExecContext(ctx, "INSERT INTO urls (short_url, original_url, user_id, expires_at, created_at, redirect_type, forward_query, forward_path, password_hash, max_clicks) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+		shortURL, store.OriginalURL, store.UserID, toNullTime(store.ExpiresAt), store.CreatedAt, store.RedirectType, store.ForwardQuery, store.ForwardPath, store.PasswordHash, store.MaxClicks)
+	if err != nil && isUniqueViolation(err) {
+		if taken, _ := pdb.isCodeTaken(ctx, shortURL, store); taken {
+			return storage.ErrCodeCollision
+		}
+		return pdb.conflict(ctx, store)
 	}
 	return err
 }
 
-func (pdb *PostgresDB) Delete(shortURL string, userID int) error {
-	_, err := pdb.DB.Exec("UPDATE urls SET is_deleted = true WHERE short_url = $1 AND user_id = $2", shortURL, userID)
+func (pdb *PostgresDB) SetBatch(ctx context.Context, items []storage.BatchItem) error {
+	return setBatch(ctx, pdb.DB, postgresDialect, items)
+}
+
+// conflict loads the URL the user has already shortened.
+func (pdb *PostgresDB) conflict(ctx context.Context, store *storage.Store) error {
+	var existing storage.Store
+	var expiresAt sql.NullTime
+	err := pdb.DB.QueryRowContext(ctx, "SELECT short_url, original_url, user_id, is_deleted, expires_at FROM urls WHERE user_id = $1 AND original_url = $2",
+		store.UserID, store.OriginalURL).Scan(&existing.ShortURL, &existing.OriginalURL, &existing.UserID, &existing.DeletedFlag, &expiresAt)
 	if err != nil {
 		return err
 	}
-	return nil
+	existing.ExpiresAt = expiresAt.Time
+	return &storage.ConflictError{Existing: existing}
+}
+
+func (pdb *PostgresDB) isCodeTaken(ctx context.Context, shortURL string, store *storage.Store) (bool, error) {
+	var existingURL string
+	var existingUserID int
+	err := pdb.DB.QueryRowContext(ctx, "SELECT original_url, user_id FROM urls WHERE short_url = $1", shortURL).Scan(&existingURL, &existingUserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return existingURL != store.OriginalURL || existingUserID != store.UserID, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation
+}
+
+func (pdb *PostgresDB) Update(ctx context.Context, shortURL string, value *storage.Store) error {
+	return updateURL(ctx, pdb.DB, postgresDialect, shortURL, value)
 }
 
-func (pdb *PostgresDB) GetUserURLS(ctx context.Context, userID int) ([]storage.Store, error) {
-	qc, err := pdb.DB.QueryContext(ctx, "SELECT original_url, short_url FROM urls WHERE user_id = $1", userID)
+func (pdb *PostgresDB) GetURLHistory(ctx context.Context, shortURL string) ([]storage.Change, error) {
+	return urlHistory(ctx, pdb.DB, postgresDialect, shortURL)
+}
+
+func (pdb *PostgresDB) Delete(ctx context.Context, shortURL string, userID int) error {
+	res, err := pdb.DB.ExecContext(ctx, "UPDATE urls SET is_deleted = true, deleted_at = COALESCE(deleted_at, $3) WHERE short_url = $1 AND user_id = $2",
+		shortURL, userID, time.Now())
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if updated, err := res.RowsAffected(); err != nil || updated > 0 {
+		return err
 	}
-	if qc.Err() != nil {
-		return nil, qc.Err()
+	var ownerID int
+	err = pdb.DB.QueryRowContext(ctx, "SELECT user_id FROM urls WHERE short_url = $1", shortURL).Scan(&ownerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrNotFound
 	}
-	defer qc.Close()
-	var urls []storage.Store
-	for qc.Next() {
-		var store storage.Store
-		err := qc.Scan(&store.OriginalURL, &store.ShortURL)
+	if err != nil {
+		return err
+	}
+	return storage.ErrForbidden
+}
+
+func (pdb *PostgresDB) DeleteBatch(ctx context.Context, userID int, shortURLs []string) error {
+	_, err := pdb.DB.ExecContext(ctx, "UPDATE urls SET is_deleted = true, deleted_at = COALESCE(deleted_at, $3) WHERE short_url = ANY($1) AND user_id = $2",
+		shortURLs, userID, time.Now())
+	return err
+}
+
+func (pdb *PostgresDB) DeleteExpired(ctx context.Context, now time.Time, limit int) (int, error) {
+	return deleteExpired(ctx, pdb.DB, postgresDialect, now, limit)
+}
+
+func (pdb *PostgresDB) Undelete(ctx context.Context, userID int, shortURLs []string) ([]string, error) {
+	return undelete(ctx, pdb.DB, postgresDialect, userID, shortURLs)
+}
+
+func (pdb *PostgresDB) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	return purgeDeleted(ctx, pdb.DB, postgresDialect, before, limit)
+}
+
+func (pdb *PostgresDB) GetUserURLS(ctx context.Context, userID int, opts storage.ListOptions) (storage.Page, error) {
+	return listUserURLS(ctx, pdb.DB, postgresDialect, userID, opts)
+}
+
+func (pdb *PostgresDB) GetUserID(ctx context.Context) (int, error) {
+	var userID sql.NullInt64
+	err := pdb.DB.QueryRowContext(ctx, "SELECT MAX(user_id) FROM urls").Scan(&userID)
+	return int(userID.Int64), err
+}
+
+func (pdb *PostgresDB) SaveClicks(ctx context.Context, clicks []storage.Click) error {
+	tx, err := pdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO clicks (short_url, clicked_at, referrer, user_agent, ip_hash) VALUES ($1, $2, $3, $4, $5)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, click := range clicks {
+		_, err := stmt.ExecContext(ctx, click.ShortURL, click.ClickedAt, click.Referrer, click.UserAgent, click.IPHash)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		urls = append(urls, store)
 	}
-	return urls, nil
+	for shortURL, n := range storage.CountClicks(clicks) {
+		_, err := tx.ExecContext(ctx, "UPDATE urls SET click_count = click_count + $1 WHERE short_url = $2", n, shortURL)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func (pdb *PostgresDB) GetUserID() int {
-	qr := pdb.DB.QueryRow("SELECT user_id FROM urls GROUP BY user_id ORDER BY user_id DESC LIMIT 1")
-	if qr.Err() != nil {
-		return 0
+func (pdb *PostgresDB) GetClickStats(ctx context.Context, shortURL string) (storage.ClickStats, error) {
+	stats := storage.ClickStats{Daily: make([]storage.DailyClicks, 0)}
+	err := pdb.DB.QueryRowContext(ctx, "SELECT COUNT(*), COUNT(DISTINCT ip_hash) FROM clicks WHERE short_url = $1", shortURL).
+		Scan(&stats.TotalClicks, &stats.UniqueVisitors)
+	if err != nil {
+		return stats, err
 	}
-	var userID int
-	err := qr.Scan(&userID)
+
+	rows, err := pdb.DB.QueryContext(ctx, `SELECT to_char(date_trunc('day', clicked_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD') AS day, COUNT(*)
+		FROM clicks WHERE short_url = $1 GROUP BY day ORDER BY day`, shortURL)
 	if err != nil {
-		return 0
+		return stats, err
 	}
-	return userID
+	defer rows.Close()
+	for rows.Next() {
+		var daily storage.DailyClicks
+		if err := rows.Scan(&daily.Date, &daily.Clicks); err != nil {
+			return stats, err
+		}
+		stats.Daily = append(stats.Daily, daily)
+	}
+	return stats, rows.Err()
+}
+
+func (pdb *PostgresDB) NextUserID(ctx context.Context) (int, error) {
+	var userID int
+	err := pdb.DB.QueryRowContext(ctx, "SELECT nextval('user_id_seq')").Scan(&userID)
+	return userID, err
 }
 
-func isTableExist(pdb *PostgresDB, table string) bool {
+func (pdb *PostgresDB) NextCodeNumber(ctx context.Context) (int, error) {
 	var n int
-	err := pdb.DB.QueryRow("SELECT 1 FROM information_schema.tables WHERE table_name = $1", table).Scan(&n)
-	return err == nil
+	err := pdb.DB.QueryRowContext(ctx, "SELECT nextval('short_code_seq')").Scan(&n)
+	return n, err
 }
 
-func CreateDatabaseTable(pdb *PostgresDB) error {
-	if isTableExist(pdb, "urls") {
-		return nil
-	}
-	_, err := pdb.DB.Exec(`CREATE TABLE urls("original_url" TEXT UNIQUE, "short_url" TEXT, "user_id" INTEGER, "is_deleted" BOOLEAN DEFAULT FALSE)`)
-	if err != nil {
+// SeedCodeNumbers moves the short code sequence past every stored code, so
+// codes issued before the sequence existed are not issued again.
+func (pdb *PostgresDB) SeedCodeNumbers(ctx context.Context, decode func(code string) (int, bool)) error {
+	last, err := maxCodeNumber(ctx, pdb.DB, decode)
+	if err != nil || last == 0 {
 		return err
 	}
-	return nil
+	_, err = pdb.DB.ExecContext(ctx, "SELECT setval('short_code_seq', $1) FROM short_code_seq WHERE last_value < $1 OR NOT is_called AND last_value = $1", last)
+	return err
+}
+
+func toNullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
 }
 
 func (pdb *PostgresDB) OpenConnection() error {
@@ -109,6 +246,13 @@ func (pdb *PostgresDB) OpenConnection() error {
 	return nil
 }
 
+func (pdb *PostgresDB) Ping(ctx context.Context) error {
+	if pdb.DB == nil {
+		return errors.New("database is not configured")
+	}
+	return pdb.DB.PingContext(ctx)
+}
+
 func (pdb *PostgresDB) CloseConnection() error {
 	err := pdb.DB.Close()
 	return err
@@ -0,0 +1,32 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+)
+
+// useClick counts a redirect with a single conditional update, so concurrent
+// redirects cannot both take the last click. When no row is updated the URL
+// is looked up again only to tell why.
+func useClick(ctx context.Context, db *sql.DB, d dialect, shortURL string) (int64, error) {
+	var used int64
+	err := db.QueryRowContext(ctx, "UPDATE urls SET used_clicks = used_clicks + 1 WHERE short_url = "+d.bind(1)+
+		" AND NOT is_deleted AND (max_clicks = 0 OR used_clicks < max_clicks) RETURNING used_clicks", shortURL).Scan(&used)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return used, err
+	}
+
+	var deleted bool
+	err = db.QueryRowContext(ctx, "SELECT is_deleted, used_clicks FROM urls WHERE short_url = "+d.bind(1), shortURL).Scan(&deleted, &used)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return 0, storage.ErrNotFound
+	case err != nil:
+		return 0, err
+	case deleted:
+		return 0, storage.ErrDeleted
+	}
+	return used, storage.ErrClickLimit
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"strconv"
+	"strings"
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// listUserURLS reads one page with a keyset query, so every page costs the
+// same however deep into the listing it is.
+func listUserURLS(ctx context.Context, db *sql.DB, d dialect, userID int, opts storage.ListOptions) (storage.Page, error) {
+	cursor, err := opts.ParseCursor()
+	if err != nil {
+		return storage.Page{}, err
+	}
+
+	args := []any{userID}
+	bind := func(arg any) string {
+		args = append(args, arg)
+		return d.bind(len(args))
+	}
+	conditions := []string{"user_id = " + d.bind(1)}
+	switch {
+	case opts.Trash:
+		conditions = append(conditions, "is_deleted")
+	case !opts.IncludeDeleted:
+		conditions = append(conditions, "NOT is_deleted")
+	}
+	if opts.Query != "" {
+		conditions = append(conditions, `LOWER(original_url) LIKE `+bind("%"+likeEscaper.Replace(strings.ToLower(opts.Query))+"%")+` ESCAPE '\'`)
+	}
+
+	var column, order string
+	var cursorValue any
+	switch opts.Sort {
+	case storage.SortClicks:
+		column, order = "click_count", "DESC"
+		if cursor != nil {
+			cursorValue = cursor.Clicks
+		}
+	case storage.SortOriginalURL:
+		column, order = "original_url", "ASC"
+		if cursor != nil {
+			cursorValue = cursor.OriginalURL
+		}
+	case storage.SortDeleted:
+		column, order = "deleted_at", "DESC"
+		if cursor != nil {
+			cursorValue = d.nullTime(cursor.DeletedAt)
+		}
+	default:
+		column, order = "created_at", "DESC"
+		if cursor != nil {
+			cursorValue = d.nullTime(cursor.CreatedAt)
+		}
+	}
+	if cursor != nil {
+		op := "<"
+		if order == "ASC" {
+			op = ">"
+		}
+		conditions = append(conditions, "("+column+", short_url) "+op+" ("+bind(cursorValue)+", "+bind(cursor.ShortURL)+")")
+	}
+
+	query := "SELECT short_url, original_url, user_id, is_deleted, expires_at, created_at, click_count, deleted_at, redirect_type, forward_query, forward_path, password_hash, max_clicks, used_clicks FROM urls WHERE " +
+		strings.Join(conditions, " AND ") + " ORDER BY " + column + " " + order + ", short_url " + order
+	if opts.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(opts.Limit+1)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return storage.Page{}, err
+	}
+	defer rows.Close()
+
+	var page storage.Page
+	for rows.Next() {
+		var store storage.Store
+		var expiresAt, createdAt, deletedAt sql.NullTime
+		err := rows.Scan(&store.ShortURL, &store.OriginalURL, &store.UserID, &store.DeletedFlag, &expiresAt, &createdAt, &store.Clicks, &deletedAt, &store.RedirectType, &store.ForwardQuery, &store.ForwardPath, &store.PasswordHash, &store.MaxClicks, &store.UsedClicks)
+		if err != nil {
+			return storage.Page{}, err
+		}
+		store.ExpiresAt, store.CreatedAt, store.DeletedAt = expiresAt.Time, createdAt.Time, deletedAt.Time
+		page.URLs = append(page.URLs, store)
+	}
+	if err := rows.Err(); err != nil {
+		return storage.Page{}, err
+	}
+
+	if opts.Limit > 0 && len(page.URLs) > opts.Limit {
+		page.URLs = page.URLs[:opts.Limit]
+		page.NextCursor = storage.NewCursor(opts.Sort, page.URLs[opts.Limit-1])
+	}
+	return page, nil
+}
@@ -0,0 +1,238 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationsFS embed.FS
+
+// migrationLockID is the pg_advisory_lock key guarding schema changes, so
+// replicas starting at the same time apply every migration exactly once.
+const migrationLockID = 7310251
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied bool
+}
+
+// Migrator applies the embedded migrations of one SQL dialect.
+type Migrator struct {
+	db            *sql.DB
+	dir           string
+	lock          func(ctx context.Context, conn *sql.Conn) error
+	unlock        func(ctx context.Context, conn *sql.Conn) error
+	insertVersion string
+	deleteVersion string
+}
+
+func NewPostgresMigrator(pdb *PostgresDB) *Migrator {
+	return &Migrator{
+		db:  pdb.DB,
+		dir: "migrations/postgres",
+		lock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID)
+			return err
+		},
+		unlock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID)
+			return err
+		},
+		insertVersion: "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)",
+		deleteVersion: "DELETE FROM schema_migrations WHERE version = $1",
+	}
+}
+
+// NewSQLiteMigrator needs no cross-process lock: the database file belongs
+// to a single server and SQLite serialises writers itself.
+func NewSQLiteMigrator(sdb *SQLiteDB) *Migrator {
+	noop := func(context.Context, *sql.Conn) error { return nil }
+	return &Migrator{
+		db:            sdb.DB,
+		dir:           "migrations/sqlite",
+		lock:          noop,
+		unlock:        noop,
+		insertVersion: "INSERT INTO schema_migrations (version, name) VALUES (?, ?)",
+		deleteVersion: "DELETE FROM schema_migrations WHERE version = ?",
+	}
+}
+
+func (m *Migrator) Migrations() ([]Migration, error) {
+	return loadMigrations(migrationsFS, m.dir)
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: name must be NNNN_name.up.sql or NNNN_name.down.sql", name)
+		}
+		versionStr, title, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: bad version: %w", name, err)
+		}
+		data, err := fs.ReadFile(fsys, dir+"/"+name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		}
+		if m.Name != title {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, title)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn, migrations []Migration, applied map[int]bool) error {
+		for _, mg := range migrations {
+			if applied[mg.Version] {
+				continue
+			}
+			err := runMigration(ctx, conn, mg.Up, m.insertVersion, mg.Version, mg.Name)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", mg.Version, mg.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back the last steps applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *sql.Conn, migrations []Migration, applied map[int]bool) error {
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			mg := migrations[i]
+			if !applied[mg.Version] {
+				continue
+			}
+			if mg.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down script", mg.Version, mg.Name)
+			}
+			err := runMigration(ctx, conn, mg.Down, m.deleteVersion, mg.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", mg.Version, mg.Name, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.withLock(ctx, func(conn *sql.Conn, migrations []Migration, applied map[int]bool) error {
+		for _, mg := range migrations {
+			statuses = append(statuses, MigrationStatus{Migration: mg, Applied: applied[mg.Version]})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+func (m *Migrator) withLock(ctx context.Context, fn func(*sql.Conn, []Migration, map[int]bool) error) (err error) {
+	migrations, err := m.Migrations()
+	if err != nil {
+		return err
+	}
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := m.lock(ctx, conn); err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, m.unlock(context.Background(), conn))
+	}()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations(
+		"version" BIGINT PRIMARY KEY, "name" TEXT NOT NULL, "applied_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return fn(conn, migrations, applied)
+}
+
+func runMigration(ctx context.Context, conn *sql.Conn, script string, bookkeeping string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	for _, dir := range []string{"migrations/postgres", "migrations/sqlite"} {
+		t.Run(dir, func(t *testing.T) {
+			migrations, err := loadMigrations(migrationsFS, dir)
+			require.NoError(t, err)
+			require.NotEmpty(t, migrations)
+			for i, m := range migrations {
+				assert.Equal(t, i+1, m.Version, "Migration versions must be sequential")
+				assert.NotEmpty(t, m.Down, "Migration %d has no down script", m.Version)
+			}
+		})
+	}
+}
+
+func TestLoadMigrationsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+	}{
+		{name: "bad direction", files: fstest.MapFS{"m/0001_init.sideways.sql": {Data: []byte("SELECT 1")}}},
+		{name: "bad version", files: fstest.MapFS{"m/first_init.up.sql": {Data: []byte("SELECT 1")}}},
+		{name: "missing up", files: fstest.MapFS{"m/0001_init.down.sql": {Data: []byte("SELECT 1")}}},
+		{name: "conflicting names", files: fstest.MapFS{
+			"m/0001_init.up.sql":  {Data: []byte("SELECT 1")},
+			"m/0001_other.up.sql": {Data: []byte("SELECT 1")},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadMigrations(test.files, "m")
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS urls(
+    "original_url" TEXT UNIQUE,
+    "short_url" TEXT,
+    "user_id" INTEGER,
+    "is_deleted" BOOLEAN DEFAULT FALSE
+);
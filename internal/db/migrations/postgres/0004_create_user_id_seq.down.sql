@@ -0,0 +1 @@
+DROP SEQUENCE IF EXISTS user_id_seq;
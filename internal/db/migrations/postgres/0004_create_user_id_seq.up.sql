@@ -0,0 +1,9 @@
+CREATE SEQUENCE IF NOT EXISTS user_id_seq;
+-- Continue after the highest user id in use; on an empty table the sequence
+-- stays uncalled so the first id is 1.
+SELECT setval('user_id_seq', GREATEST(start.last_id, 1), start.last_id > 0) FROM (
+    SELECT GREATEST(
+        (SELECT COALESCE(MAX(user_id), 0) FROM urls),
+        (SELECT CASE WHEN is_called THEN last_value ELSE 0 END FROM user_id_seq)
+    ) AS last_id
+) AS start;
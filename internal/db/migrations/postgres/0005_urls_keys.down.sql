@@ -0,0 +1,3 @@
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_user_id_original_url_key;
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_pkey;
+ALTER TABLE urls ADD CONSTRAINT urls_original_url_key UNIQUE (original_url);
@@ -0,0 +1,19 @@
+-- Rows sharing a short code, or without one, cannot be told apart safely, so
+-- the migration stops and leaves the clean-up to the operator.
+DO $$
+DECLARE
+    duplicated bigint;
+    missing bigint;
+BEGIN
+    SELECT COUNT(short_url) - COUNT(DISTINCT short_url), COUNT(*) - COUNT(short_url)
+        INTO duplicated, missing FROM urls;
+    IF duplicated > 0 OR missing > 0 THEN
+        RAISE EXCEPTION 'urls has % extra rows for duplicated short codes and % rows without a short code', duplicated, missing
+            USING HINT = 'Find them with SELECT short_url, COUNT(*) FROM urls GROUP BY short_url HAVING short_url IS NULL OR COUNT(*) > 1, remove the unwanted rows and migrate again.';
+    END IF;
+END
+$$;
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_short_url_key;
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_original_url_key;
+ALTER TABLE urls ADD CONSTRAINT urls_pkey PRIMARY KEY (short_url);
+ALTER TABLE urls ADD CONSTRAINT urls_user_id_original_url_key UNIQUE (user_id, original_url);
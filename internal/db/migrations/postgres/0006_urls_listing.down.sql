@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS urls_user_id_original_url_idx;
+DROP INDEX IF EXISTS urls_user_id_click_count_idx;
+DROP INDEX IF EXISTS urls_user_id_created_at_idx;
+ALTER TABLE urls DROP COLUMN IF EXISTS click_count;
+ALTER TABLE urls DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,8 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS click_count BIGINT NOT NULL DEFAULT 0;
+UPDATE urls SET click_count = c.clicks
+    FROM (SELECT short_url, COUNT(*) AS clicks FROM clicks GROUP BY short_url) c
+    WHERE urls.short_url = c.short_url;
+CREATE INDEX IF NOT EXISTS urls_user_id_created_at_idx ON urls (user_id, created_at DESC, short_url DESC);
+CREATE INDEX IF NOT EXISTS urls_user_id_click_count_idx ON urls (user_id, click_count DESC, short_url DESC);
+CREATE INDEX IF NOT EXISTS urls_user_id_original_url_idx ON urls (user_id, original_url, short_url);
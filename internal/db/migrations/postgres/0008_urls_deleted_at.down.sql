@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS urls_user_id_deleted_at_idx;
+DROP INDEX IF EXISTS urls_deleted_at_idx;
+ALTER TABLE urls DROP COLUMN IF EXISTS deleted_at;
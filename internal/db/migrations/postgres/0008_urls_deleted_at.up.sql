@@ -0,0 +1,4 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+UPDATE urls SET deleted_at = now() WHERE is_deleted AND deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS urls_deleted_at_idx ON urls (deleted_at) WHERE is_deleted;
+CREATE INDEX IF NOT EXISTS urls_user_id_deleted_at_idx ON urls (user_id, deleted_at DESC, short_url DESC) WHERE is_deleted;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS urls(
+    "short_url" TEXT PRIMARY KEY,
+    "original_url" TEXT NOT NULL,
+    "user_id" INTEGER NOT NULL,
+    "is_deleted" BOOLEAN NOT NULL DEFAULT FALSE,
+    "expires_at" TIMESTAMP,
+    UNIQUE ("user_id", "original_url")
+);
+CREATE INDEX IF NOT EXISTS urls_expires_at_idx ON urls (expires_at);
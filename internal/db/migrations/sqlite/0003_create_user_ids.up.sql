@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS user_ids(
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT
+);
+INSERT INTO user_ids (id) SELECT (SELECT MAX(user_id) FROM urls)
+WHERE EXISTS (SELECT 1 FROM urls WHERE user_id > 0);
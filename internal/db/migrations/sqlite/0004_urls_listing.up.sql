@@ -0,0 +1,7 @@
+ALTER TABLE urls ADD COLUMN created_at TIMESTAMP;
+ALTER TABLE urls ADD COLUMN click_count INTEGER NOT NULL DEFAULT 0;
+UPDATE urls SET created_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now');
+UPDATE urls SET click_count = (SELECT COUNT(*) FROM clicks WHERE clicks.short_url = urls.short_url);
+CREATE INDEX IF NOT EXISTS urls_user_id_created_at_idx ON urls (user_id, created_at DESC, short_url DESC);
+CREATE INDEX IF NOT EXISTS urls_user_id_click_count_idx ON urls (user_id, click_count DESC, short_url DESC);
+CREATE INDEX IF NOT EXISTS urls_user_id_original_url_idx ON urls (user_id, original_url, short_url);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS url_history(
+    "short_url" TEXT NOT NULL,
+    "original_url" TEXT NOT NULL,
+    "changed_at" TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS url_history_short_url_idx ON url_history (short_url, changed_at);
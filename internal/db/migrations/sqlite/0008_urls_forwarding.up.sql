@@ -0,0 +1,2 @@
+ALTER TABLE urls ADD COLUMN forward_query BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE urls ADD COLUMN forward_path BOOLEAN NOT NULL DEFAULT FALSE;
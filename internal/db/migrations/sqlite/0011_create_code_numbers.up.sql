@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS code_numbers(
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT
+);
@@ -0,0 +1,292 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type SQLiteDB struct {
+	DB *sql.DB
+}
+
+func NewSQLiteDB() *SQLiteDB {
+	return &SQLiteDB{}
+}
+
+// ParseSQLiteURL turns a sqlite://path storage URL into the database file path.
+func ParseSQLiteURL(storageURL string) (string, bool) {
+	path, ok := strings.CutPrefix(storageURL, "sqlite://")
+	if !ok || path == "" {
+		return "", false
+	}
+	return path, true
+}
+
+func (sdb *SQLiteDB) OpenConnection(path string) error {
+	dsn := "file:" + path + "?" + url.Values{
+		"_pragma": {"busy_timeout(5000)", "journal_mode(WAL)", "foreign_keys(1)"},
+		// Take the write lock up front so checks and writes in one
+		// transaction cannot interleave with another writer.
+		"_txlock": {"immediate"},
+	}.Encode()
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return err
+	}
+	sdb.DB = db
+	return nil
+}
+
+func (sdb *SQLiteDB) CloseConnection() error {
+	return sdb.DB.Close()
+}
+
+func (sdb *SQLiteDB) Ping(ctx context.Context) error {
+	return sdb.DB.PingContext(ctx)
+}
+
+func (sdb *SQLiteDB) Get(ctx context.Context, shortURL string) (storage.Store, error) {
+	var store storage.Store
+	var expiresAt, createdAt, deletedAt sql.NullTime
+	err := sdb.DB.QueryRowContext(ctx, "SELECT original_url, user_id, is_deleted, expires_at, created_at, click_count, deleted_at, redirect_type, forward_query, forward_path, password_hash, max_clicks, used_clicks FROM urls WHERE short_url = ?", shortURL).
+		Scan(&store.OriginalURL, &store.UserID, &store.DeletedFlag, &expiresAt, &createdAt, &store.Clicks, &deletedAt, &store.RedirectType, &store.ForwardQuery, &store.ForwardPath, &store.PasswordHash, &store.MaxClicks, &store.UsedClicks)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Store{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.Store{}, err
+	}
+	store.ExpiresAt, store.CreatedAt, store.DeletedAt = expiresAt.Time, createdAt.Time, deletedAt.Time
+	if store.DeletedFlag {
+		return store, storage.ErrDeleted
+	}
+	return store, nil
+}
+
+func (sdb *SQLiteDB) Set(ctx context.Context, shortURL string, store *storage.Store) error {
+	if taken, err := sdb.isCodeTaken(ctx, shortURL, store); err != nil || taken {
+		if err == nil {
+			err = storage.ErrCodeCollision
+		}
+		return err
+	}
+	if store.CreatedAt.IsZero() {
+		store.CreatedAt = time.Now().UTC()
+	}
+	_, err := sdb.DB.ExecContext(ctx, "INSERT INTO urls (short_url, original_url, user_id, expires_at, created_at, redirect_type, forward_query, forward_path, password_hash, max_clicks) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		shortURL, store.OriginalURL, store.UserID, toNullUTCTime(store.ExpiresAt), store.CreatedAt.UTC(), store.RedirectType, store.ForwardQuery, store.ForwardPath, store.PasswordHash, store.MaxClicks)
+	if err != nil && isSQLiteConstraintError(err) {
+		if taken, _ := sdb.isCodeTaken(ctx, shortURL, store); taken {
+			return storage.ErrCodeCollision
+		}
+		return sdb.conflict(ctx, store)
+	}
+	return err
+}
+
+func (sdb *SQLiteDB) SetBatch(ctx context.Context, items []storage.BatchItem) error {
+	return setBatch(ctx, sdb.DB, sqliteDialect, items)
+}
+
+// conflict loads the URL the user has already shortened.
+func (sdb *SQLiteDB) conflict(ctx context.Context, store *storage.Store) error {
+	var existing storage.Store
+	var expiresAt sql.NullTime
+	err := sdb.DB.QueryRowContext(ctx, "SELECT short_url, original_url, user_id, is_deleted, expires_at FROM urls WHERE user_id = ? AND original_url = ?",
+		store.UserID, store.OriginalURL).Scan(&existing.ShortURL, &existing.OriginalURL, &existing.UserID, &existing.DeletedFlag, &expiresAt)
+	if err != nil {
+		return err
+	}
+	existing.ExpiresAt = expiresAt.Time
+	return &storage.ConflictError{Existing: existing}
+}
+
+func (sdb *SQLiteDB) isCodeTaken(ctx context.Context, shortURL string, store *storage.Store) (bool, error) {
+	var existingURL string
+	var existingUserID int
+	err := sdb.DB.QueryRowContext(ctx, "SELECT original_url, user_id FROM urls WHERE short_url = ?", shortURL).Scan(&existingURL, &existingUserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return existingURL != store.OriginalURL || existingUserID != store.UserID, nil
+}
+
+func isSQLiteConstraintError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code()
+	return code == sqlite3.SQLITE_CONSTRAINT_UNIQUE || code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+func (sdb *SQLiteDB) UseClick(ctx context.Context, shortURL string) (int64, error) {
+	return useClick(ctx, sdb.DB, sqliteDialect, shortURL)
+}
+
+func (sdb *SQLiteDB) Update(ctx context.Context, shortURL string, value *storage.Store) error {
+	return updateURL(ctx, sdb.DB, sqliteDialect, shortURL, value)
+}
+
+func (sdb *SQLiteDB) GetURLHistory(ctx context.Context, shortURL string) ([]storage.Change, error) {
+	return urlHistory(ctx, sdb.DB, sqliteDialect, shortURL)
+}
+
+func (sdb *SQLiteDB) Delete(ctx context.Context, shortURL string, userID int) error {
+	res, err := sdb.DB.ExecContext(ctx, "UPDATE urls SET is_deleted = TRUE, deleted_at = COALESCE(deleted_at, ?) WHERE short_url = ? AND user_id = ?",
+		time.Now().UTC(), shortURL, userID)
+	if err != nil {
+		return err
+	}
+	if updated, err := res.RowsAffected(); err != nil || updated > 0 {
+		return err
+	}
+	var ownerID int
+	err = sdb.DB.QueryRowContext(ctx, "SELECT user_id FROM urls WHERE short_url = ?", shortURL).Scan(&ownerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return storage.ErrForbidden
+}
+
+func (sdb *SQLiteDB) DeleteBatch(ctx context.Context, userID int, shortURLs []string) error {
+	tx, err := sdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "UPDATE urls SET is_deleted = TRUE, deleted_at = COALESCE(deleted_at, ?) WHERE short_url = ? AND user_id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	for _, shortURL := range shortURLs {
+		if _, err := stmt.ExecContext(ctx, now, shortURL, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (sdb *SQLiteDB) DeleteExpired(ctx context.Context, now time.Time, limit int) (int, error) {
+	return deleteExpired(ctx, sdb.DB, sqliteDialect, now, limit)
+}
+
+func (sdb *SQLiteDB) Undelete(ctx context.Context, userID int, shortURLs []string) ([]string, error) {
+	return undelete(ctx, sdb.DB, sqliteDialect, userID, shortURLs)
+}
+
+func (sdb *SQLiteDB) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	return purgeDeleted(ctx, sdb.DB, sqliteDialect, before, limit)
+}
+
+func (sdb *SQLiteDB) GetUserURLS(ctx context.Context, userID int, opts storage.ListOptions) (storage.Page, error) {
+	return listUserURLS(ctx, sdb.DB, sqliteDialect, userID, opts)
+}
+
+func (sdb *SQLiteDB) GetUserID(ctx context.Context) (int, error) {
+	var userID sql.NullInt64
+	err := sdb.DB.QueryRowContext(ctx, "SELECT MAX(user_id) FROM urls").Scan(&userID)
+	return int(userID.Int64), err
+}
+
+func (sdb *SQLiteDB) NextUserID(ctx context.Context) (int, error) {
+	res, err := sdb.DB.ExecContext(ctx, "INSERT INTO user_ids DEFAULT VALUES")
+	if err != nil {
+		return 0, err
+	}
+	userID, err := res.LastInsertId()
+	return int(userID), err
+}
+
+func (sdb *SQLiteDB) NextCodeNumber(ctx context.Context) (int, error) {
+	res, err := sdb.DB.ExecContext(ctx, "INSERT INTO code_numbers DEFAULT VALUES")
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.LastInsertId()
+	return int(n), err
+}
+
+// SeedCodeNumbers moves the short code sequence past every stored code, so
+// codes issued before the sequence existed are not issued again.
+func (sdb *SQLiteDB) SeedCodeNumbers(ctx context.Context, decode func(code string) (int, bool)) error {
+	last, err := maxCodeNumber(ctx, sdb.DB, decode)
+	if err != nil || last == 0 {
+		return err
+	}
+	_, err = sdb.DB.ExecContext(ctx, "INSERT INTO code_numbers (id) SELECT ? WHERE ? > (SELECT COALESCE(MAX(id), 0) FROM code_numbers)", last, last)
+	return err
+}
+
+func (sdb *SQLiteDB) SaveClicks(ctx context.Context, clicks []storage.Click) error {
+	tx, err := sdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO clicks (short_url, clicked_at, referrer, user_agent, ip_hash) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, click := range clicks {
+		_, err := stmt.ExecContext(ctx, click.ShortURL, click.ClickedAt.UTC(), click.Referrer, click.UserAgent, click.IPHash)
+		if err != nil {
+			return err
+		}
+	}
+	for shortURL, n := range storage.CountClicks(clicks) {
+		_, err := tx.ExecContext(ctx, "UPDATE urls SET click_count = click_count + ? WHERE short_url = ?", n, shortURL)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (sdb *SQLiteDB) GetClickStats(ctx context.Context, shortURL string) (storage.ClickStats, error) {
+	stats := storage.ClickStats{Daily: make([]storage.DailyClicks, 0)}
+	err := sdb.DB.QueryRowContext(ctx, "SELECT COUNT(*), COUNT(DISTINCT ip_hash) FROM clicks WHERE short_url = ?", shortURL).
+		Scan(&stats.TotalClicks, &stats.UniqueVisitors)
+	if err != nil {
+		return stats, err
+	}
+
+	rows, err := sdb.DB.QueryContext(ctx, `SELECT substr(clicked_at, 1, 10) AS day, COUNT(*)
+		FROM clicks WHERE short_url = ? GROUP BY day ORDER BY day`, shortURL)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var daily storage.DailyClicks
+		if err := rows.Scan(&daily.Date, &daily.Clicks); err != nil {
+			return stats, err
+		}
+		stats.Daily = append(stats.Daily, daily)
+	}
+	return stats, rows.Err()
+}
+
+func toNullUTCTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
@@ -0,0 +1,349 @@
+package db
+
+import (
+	"context"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/shortcode"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestSQLiteDB(t *testing.T) *SQLiteDB {
+	t.Helper()
+	sdb := NewSQLiteDB()
+	require.NoError(t, sdb.OpenConnection(filepath.Join(t.TempDir(), "shortener.db")))
+	t.Cleanup(func() { _ = sdb.CloseConnection() })
+	require.NoError(t, NewSQLiteMigrator(sdb).Up(context.Background()))
+	return sdb
+}
+
+func TestParseSQLiteURL(t *testing.T) {
+	path, ok := ParseSQLiteURL("sqlite:///var/lib/shortener.db")
+	assert.True(t, ok)
+	assert.Equal(t, "/var/lib/shortener.db", path)
+
+	_, ok = ParseSQLiteURL("postgres://localhost/db")
+	assert.False(t, ok)
+	_, ok = ParseSQLiteURL("sqlite://")
+	assert.False(t, ok)
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	sdb := newTestSQLiteDB(t)
+	ctx := context.Background()
+	migrator := NewSQLiteMigrator(sdb)
+
+	require.NoError(t, migrator.Up(ctx), "Up must be idempotent")
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	for _, st := range statuses {
+		assert.True(t, st.Applied, "Migration %d is not applied", st.Version)
+	}
+
+	require.NoError(t, migrator.Down(ctx, len(statuses)))
+	require.NoError(t, migrator.Up(ctx))
+}
+
+func TestSQLiteURLs(t *testing.T) {
+	sdb := newTestSQLiteDB(t)
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	require.NoError(t, sdb.Set(ctx, "abc", &storage.Store{OriginalURL: "https://a.example", UserID: 1, ExpiresAt: expiresAt}))
+	require.NoError(t, sdb.Set(ctx, "def", &storage.Store{OriginalURL: "https://b.example", UserID: 1}))
+
+	got, err := sdb.Get(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://a.example", got.OriginalURL)
+	assert.Equal(t, 1, got.UserID)
+	assert.True(t, expiresAt.Equal(got.ExpiresAt))
+
+	err = sdb.Set(ctx, "xyz", &storage.Store{OriginalURL: "https://a.example", UserID: 1})
+	var conflict *storage.ConflictError
+	require.ErrorAs(t, err, &conflict, "The same user must not shorten a url twice")
+	assert.Equal(t, "abc", conflict.Existing.ShortURL)
+	err = sdb.Set(ctx, "abc", &storage.Store{OriginalURL: "https://c.example", UserID: 2})
+	assert.ErrorIs(t, err, storage.ErrCodeCollision)
+	assert.NoError(t, sdb.Set(ctx, "ghi", &storage.Store{OriginalURL: "https://a.example", UserID: 2}))
+
+	page, err := sdb.GetUserURLS(ctx, 1, storage.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, page.URLs, 2)
+	maxUserID, err := sdb.GetUserID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, maxUserID)
+
+	assert.ErrorIs(t, sdb.Delete(ctx, "ghi", 1), storage.ErrForbidden)
+	assert.ErrorIs(t, sdb.Delete(ctx, "missing", 1), storage.ErrNotFound)
+
+	require.NoError(t, sdb.DeleteBatch(ctx, 1, []string{"abc", "ghi"}))
+	got, err = sdb.Get(ctx, "abc")
+	assert.ErrorIs(t, err, storage.ErrDeleted)
+	assert.True(t, got.DeletedFlag)
+	_, err = sdb.Get(ctx, "ghi")
+	assert.NoError(t, err, "Only the owner may delete a url")
+
+	require.NoError(t, sdb.SaveClicks(ctx, []storage.Click{{ShortURL: "abc", ClickedAt: time.Now()}}))
+	deleted, err := sdb.DeleteExpired(ctx, expiresAt.Add(time.Second), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	_, err = sdb.Get(ctx, "abc")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	stats, err := sdb.GetClickStats(ctx, "abc")
+	require.NoError(t, err)
+	assert.Zero(t, stats.TotalClicks, "Expired urls must take their clicks with them")
+}
+
+func TestSQLiteNextUserID(t *testing.T) {
+	sdb := newTestSQLiteDB(t)
+	ctx := context.Background()
+
+	first, err := sdb.NextUserID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, first, "A fresh database must start with user 1")
+	second, err := sdb.NextUserID(ctx)
+	require.NoError(t, err)
+	assert.Greater(t, second, first)
+
+	migrator := NewSQLiteMigrator(sdb)
+	migrations, err := migrator.Migrations()
+	require.NoError(t, err)
+	require.NoError(t, migrator.Down(ctx, len(migrations)-2))
+	_, err = sdb.DB.ExecContext(ctx, "INSERT INTO urls (short_url, original_url, user_id) VALUES ('abc', 'https://a.example', 7)")
+	require.NoError(t, err)
+	require.NoError(t, migrator.Up(ctx))
+	next, err := sdb.NextUserID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 8, next, "User ids must continue after those already in urls")
+}
+
+func TestSQLiteCodeNumbers(t *testing.T) {
+	sdb := newTestSQLiteDB(t)
+	ctx := context.Background()
+	require.NoError(t, sdb.Set(ctx, "b", &storage.Store{OriginalURL: "https://a.example", UserID: 1}))
+	require.NoError(t, sdb.Set(ctx, "spring-sale", &storage.Store{OriginalURL: "https://b.example", UserID: 1}))
+
+	decode := func(code string) (int, bool) {
+		return shortcode.DecodeCounter(shortcode.Base62Alphabet, code)
+	}
+	require.NoError(t, sdb.SeedCodeNumbers(ctx, decode))
+	require.NoError(t, sdb.SeedCodeNumbers(ctx, decode), "Seeding again must be a no-op")
+	n, err := sdb.NextCodeNumber(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 12, n, "The sequence must continue past the stored code b")
+}
+
+func TestSQLiteClickStats(t *testing.T) {
+	sdb := newTestSQLiteDB(t)
+	ctx := context.Background()
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, sdb.SaveClicks(ctx, []storage.Click{
+		{ShortURL: "abc", ClickedAt: day, IPHash: "a"},
+		{ShortURL: "abc", ClickedAt: day.Add(time.Hour), IPHash: "a"},
+		{ShortURL: "abc", ClickedAt: day.Add(24 * time.Hour), IPHash: "b"},
+	}))
+
+	stats, err := sdb.GetClickStats(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.TotalClicks)
+	assert.Equal(t, 2, stats.UniqueVisitors)
+	assert.Equal(t, []storage.DailyClicks{{Date: "2024-05-01", Clicks: 2}, {Date: "2024-05-02", Clicks: 1}}, stats.Daily)
+}
+
+func TestSQLiteSetBatch(t *testing.T) {
+	sdb := newTestSQLiteDB(t)
+	ctx := context.Background()
+	require.NoError(t, sdb.Set(ctx, "abc", &storage.Store{OriginalURL: "https://a.example", UserID: 1}))
+
+	items := make([]storage.BatchItem, 0, 2*batchChunkSize)
+	for i := 0; i < cap(items)-2; i++ {
+		key := "k" + strconv.Itoa(i)
+		items = append(items, storage.BatchItem{ShortURL: key, Store: storage.Store{OriginalURL: "https://example.com/" + key, UserID: 1}})
+	}
+	items = append(items,
+		storage.BatchItem{ShortURL: "dup", Store: storage.Store{OriginalURL: "https://a.example", UserID: 1}},
+		storage.BatchItem{ShortURL: "abc", Store: storage.Store{OriginalURL: "https://c.example", UserID: 1}},
+	)
+
+	require.NoError(t, sdb.SetBatch(ctx, items))
+	assert.ErrorIs(t, items[len(items)-1].Err, storage.ErrCodeCollision)
+	_, err := sdb.Get(ctx, "k0")
+	assert.ErrorIs(t, err, storage.ErrNotFound, "A colliding batch must not be stored")
+
+	items[len(items)-1].ShortURL = "fresh"
+	require.NoError(t, sdb.SetBatch(ctx, items))
+	var conflict *storage.ConflictError
+	require.ErrorAs(t, items[len(items)-2].Err, &conflict)
+	assert.Equal(t, "abc", conflict.Existing.ShortURL)
+
+	page, err := sdb.GetUserURLS(ctx, 1, storage.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, page.URLs, len(items))
+}
+
+func TestSQLiteSetBatchCodeReusedInBatch(t *testing.T) {
+	sdb := newTestSQLiteDB(t)
+	ctx := context.Background()
+
+	items := []storage.BatchItem{
+		{ShortURL: "same", Store: storage.Store{OriginalURL: "https://a.example", UserID: 1}},
+		{ShortURL: "same", Store: storage.Store{OriginalURL: "https://b.example", UserID: 1}},
+		{ShortURL: "other", Store: storage.Store{OriginalURL: "https://c.example", UserID: 1}},
+	}
+	require.NoError(t, sdb.SetBatch(ctx, items))
+	assert.ErrorIs(t, items[1].Err, storage.ErrCodeCollision)
+	_, err := sdb.Get(ctx, "other")
+	assert.ErrorIs(t, err, storage.ErrNotFound, "A batch reusing a code for another url must not be stored")
+}
+
+func TestSQLiteGetUserURLSPages(t *testing.T) {
+	sdb := newTestSQLiteDB(t)
+	ctx := context.Background()
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		store := &storage.Store{OriginalURL: "https://example.com/" + strconv.Itoa(5-i) + "_%", UserID: 1, CreatedAt: created.Add(time.Duration(i) * time.Hour)}
+		require.NoError(t, sdb.Set(ctx, key, store))
+	}
+	require.NoError(t, sdb.Set(ctx, "other", &storage.Store{OriginalURL: "https://example.com/1", UserID: 2}))
+	require.NoError(t, sdb.SaveClicks(ctx, []storage.Click{
+		{ShortURL: "a", ClickedAt: created}, {ShortURL: "a", ClickedAt: created}, {ShortURL: "c", ClickedAt: created},
+		{ShortURL: "c", ClickedAt: created}, {ShortURL: "e", ClickedAt: created},
+	}))
+	require.NoError(t, sdb.Delete(ctx, "b", 1))
+
+	tests := []struct {
+		name string
+		opts storage.ListOptions
+		want []string
+	}{
+		{name: "created", opts: storage.ListOptions{Limit: 2, Sort: storage.SortCreated}, want: []string{"e", "d", "c", "a"}},
+		{name: "clicks", opts: storage.ListOptions{Limit: 2, Sort: storage.SortClicks}, want: []string{"c", "a", "e", "d"}},
+		{name: "original url", opts: storage.ListOptions{Limit: 3, Sort: storage.SortOriginalURL}, want: []string{"e", "d", "c", "a"}},
+		{name: "include deleted", opts: storage.ListOptions{Limit: 10, Sort: storage.SortCreated, IncludeDeleted: true}, want: []string{"e", "d", "c", "b", "a"}},
+		{name: "query", opts: storage.ListOptions{Limit: 10, Sort: storage.SortCreated, Query: "COM/5_%"}, want: []string{"a"}},
+		{name: "query wildcards are literal", opts: storage.ListOptions{Limit: 10, Sort: storage.SortCreated, Query: "%5"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			opts := test.opts
+			for {
+				page, err := sdb.GetUserURLS(ctx, 1, opts)
+				require.NoError(t, err)
+				for _, u := range page.URLs {
+					got = append(got, u.ShortURL)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				opts.Cursor = page.NextCursor
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	got, err := sdb.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), got.Clicks)
+	assert.True(t, created.Equal(got.CreatedAt))
+}
+
+func TestSQLiteUpdate(t *testing.T) {
+	sdb := newTestSQLiteDB(t)
+	ctx := context.Background()
+	require.NoError(t, sdb.Set(ctx, "abc", &storage.Store{OriginalURL: "https://a.example", UserID: 1}))
+	require.NoError(t, sdb.Set(ctx, "def", &storage.Store{OriginalURL: "https://b.example", UserID: 1}))
+
+	assert.ErrorIs(t, sdb.Update(ctx, "abc", &storage.Store{OriginalURL: "https://x.example", UserID: 2}), storage.ErrForbidden)
+	assert.ErrorIs(t, sdb.Update(ctx, "missing", &storage.Store{OriginalURL: "https://x.example", UserID: 1}), storage.ErrNotFound)
+	var conflict *storage.ConflictError
+	require.ErrorAs(t, sdb.Update(ctx, "abc", &storage.Store{OriginalURL: "https://b.example", UserID: 1}), &conflict)
+	assert.Equal(t, "def", conflict.Existing.ShortURL)
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	require.NoError(t, sdb.Update(ctx, "abc", &storage.Store{OriginalURL: "https://x.example", UserID: 1, ExpiresAt: expiresAt, RedirectType: 308,
+		ForwardQuery: true, PasswordHash: "hash"}))
+	got, err := sdb.Get(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://x.example", got.OriginalURL)
+	assert.True(t, expiresAt.Equal(got.ExpiresAt))
+	assert.Equal(t, 308, got.RedirectType)
+	assert.True(t, got.ForwardQuery)
+	assert.False(t, got.ForwardPath)
+	assert.Equal(t, "hash", got.PasswordHash)
+
+	history, err := sdb.GetURLHistory(ctx, "abc")
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, "https://a.example", history[0].OriginalURL)
+
+	require.NoError(t, sdb.Delete(ctx, "abc", 1))
+	assert.ErrorIs(t, sdb.Update(ctx, "abc", &storage.Store{OriginalURL: "https://y.example", UserID: 1}), storage.ErrDeleted)
+}
+
+func TestSQLiteTrash(t *testing.T) {
+	sdb := newTestSQLiteDB(t)
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, sdb.Set(ctx, key, &storage.Store{OriginalURL: "https://example.com/" + key, UserID: 1}))
+	}
+	require.NoError(t, sdb.SaveClicks(ctx, []storage.Click{{ShortURL: "b", ClickedAt: time.Now()}}))
+	require.NoError(t, sdb.Delete(ctx, "a", 1))
+	require.NoError(t, sdb.DeleteBatch(ctx, 1, []string{"b"}))
+
+	page, err := sdb.GetUserURLS(ctx, 1, storage.ListOptions{Limit: 1, Sort: storage.SortDeleted, Trash: true})
+	require.NoError(t, err)
+	require.Len(t, page.URLs, 1)
+	assert.Equal(t, "b", page.URLs[0].ShortURL)
+	assert.False(t, page.URLs[0].DeletedAt.IsZero())
+	page, err = sdb.GetUserURLS(ctx, 1, storage.ListOptions{Limit: 1, Sort: storage.SortDeleted, Trash: true, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	require.Len(t, page.URLs, 1)
+	assert.Equal(t, "a", page.URLs[0].ShortURL)
+
+	restored, err := sdb.Undelete(ctx, 1, []string{"a", "c", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, restored)
+
+	purged, err := sdb.PurgeDeleted(ctx, time.Now().Add(-time.Hour), 10)
+	require.NoError(t, err)
+	assert.Zero(t, purged, "Recently deleted urls must be kept")
+	purged, err = sdb.PurgeDeleted(ctx, time.Now().Add(time.Second), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	_, err = sdb.Get(ctx, "b")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	stats, err := sdb.GetClickStats(ctx, "b")
+	require.NoError(t, err)
+	assert.Zero(t, stats.TotalClicks, "Purging must drop the clicks too")
+}
+
+func TestSQLiteUseClick(t *testing.T) {
+	sdb := newTestSQLiteDB(t)
+	ctx := context.Background()
+	require.NoError(t, sdb.Set(ctx, "twice", &storage.Store{OriginalURL: "https://a.example", UserID: 1, MaxClicks: 2}))
+
+	for want := int64(1); want <= 2; want++ {
+		used, err := sdb.UseClick(ctx, "twice")
+		require.NoError(t, err)
+		assert.Equal(t, want, used)
+	}
+	used, err := sdb.UseClick(ctx, "twice")
+	assert.ErrorIs(t, err, storage.ErrClickLimit)
+	assert.Equal(t, int64(2), used)
+
+	require.NoError(t, sdb.Update(ctx, "twice", &storage.Store{OriginalURL: "https://a.example", UserID: 1, MaxClicks: 3}))
+	_, err = sdb.UseClick(ctx, "twice")
+	assert.NoError(t, err, "Raising the limit must allow more redirects")
+
+	require.NoError(t, sdb.Delete(ctx, "twice", 1))
+	_, err = sdb.UseClick(ctx, "twice")
+	assert.ErrorIs(t, err, storage.ErrDeleted)
+	_, err = sdb.UseClick(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
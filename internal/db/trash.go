@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+func undelete(ctx context.Context, db *sql.DB, d dialect, userID int, shortURLs []string) ([]string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes := make([]any, len(shortURLs))
+	for i, code := range shortURLs {
+		codes[i] = code
+	}
+	restored := make([]string, 0, len(shortURLs))
+	err = queryChunks(codes, func(chunk []any) error {
+		return queryCodes(ctx, tx, "UPDATE urls SET is_deleted = FALSE, deleted_at = NULL WHERE user_id = "+d.bind(1)+
+			" AND is_deleted AND short_url IN ("+d.placeholders(2, len(chunk))+") RETURNING short_url", append([]any{userID}, chunk...), &restored)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restored, tx.Commit()
+}
+
+// purgeDeleted hard-deletes URLs deleted before the given time together with
+// their clicks and history, so a code used again starts clean.
+func purgeDeleted(ctx context.Context, db *sql.DB, d dialect, before time.Time, limit int) (int, error) {
+	return removeURLs(ctx, db, d, `DELETE FROM urls WHERE is_deleted AND short_url IN (
+		SELECT short_url FROM urls WHERE is_deleted AND deleted_at < `+d.bind(1)+` LIMIT `+d.bind(2)+`) RETURNING short_url`,
+		[]any{d.nullTime(before), limit})
+}
+
+// deleteExpired removes URLs expired at now the same way.
+func deleteExpired(ctx context.Context, db *sql.DB, d dialect, now time.Time, limit int) (int, error) {
+	return removeURLs(ctx, db, d, `DELETE FROM urls WHERE short_url IN (
+		SELECT short_url FROM urls WHERE expires_at <= `+d.bind(1)+` LIMIT `+d.bind(2)+`) RETURNING short_url`,
+		[]any{d.nullTime(now), limit})
+}
+
+// removeURLs runs a DELETE on urls returning the removed codes and deletes
+// their clicks and history in the same transaction.
+func removeURLs(ctx context.Context, db *sql.DB, d dialect, query string, args []any) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var purged []string
+	if err := queryCodes(ctx, tx, query, args, &purged); err != nil {
+		return 0, err
+	}
+
+	codes := make([]any, len(purged))
+	for i, code := range purged {
+		codes[i] = code
+	}
+	for _, table := range []string{"clicks", "url_history"} {
+		err = queryChunks(codes, func(chunk []any) error {
+			_, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE short_url IN ("+d.placeholders(1, len(chunk))+")", chunk...)
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(purged), tx.Commit()
+}
+
+func queryCodes(ctx context.Context, tx *sql.Tx, query string, args []any, codes *[]string) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return err
+		}
+		*codes = append(*codes, code)
+	}
+	return rows.Err()
+}
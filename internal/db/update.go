@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"time"
+)
+
+// updateURL replaces the editable attributes of a URL and moves its previous
+// destination to url_history, both in one transaction.
+func updateURL(ctx context.Context, db *sql.DB, d dialect, shortURL string, value *storage.Store) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var prev storage.Store
+	var createdAt sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT original_url, user_id, is_deleted, created_at, click_count, used_clicks FROM urls WHERE short_url = "+d.bind(1)+d.lockRow, shortURL).
+		Scan(&prev.OriginalURL, &prev.UserID, &prev.DeletedFlag, &createdAt, &prev.Clicks, &prev.UsedClicks)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return storage.ErrNotFound
+	case err != nil:
+		return err
+	case prev.UserID != value.UserID:
+		return storage.ErrForbidden
+	case prev.DeletedFlag:
+		return storage.ErrDeleted
+	}
+
+	if prev.OriginalURL != value.OriginalURL {
+		var conflict error
+		err = queryStores(ctx, tx, "SELECT short_url, original_url, user_id, is_deleted, expires_at FROM urls WHERE user_id = "+d.bind(1)+" AND original_url = "+d.bind(2),
+			[]any{value.UserID, value.OriginalURL}, func(existing storage.Store) {
+				conflict = &storage.ConflictError{Existing: existing}
+			})
+		if err != nil {
+			return err
+		}
+		if conflict != nil {
+			return conflict
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO url_history (short_url, original_url, changed_at) VALUES ("+d.placeholders(1, 3)+")",
+			shortURL, prev.OriginalURL, d.nullTime(time.Now()))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE urls SET original_url = "+d.bind(1)+", expires_at = "+d.bind(2)+", redirect_type = "+d.bind(3)+
+		", forward_query = "+d.bind(4)+", forward_path = "+d.bind(5)+
+		", password_hash = "+d.bind(6)+", max_clicks = "+d.bind(7)+" WHERE short_url = "+d.bind(8),
+		value.OriginalURL, d.nullTime(value.ExpiresAt), value.RedirectType, value.ForwardQuery, value.ForwardPath, value.PasswordHash, value.MaxClicks, shortURL)
+	if err != nil {
+		if d.isUniqueViolation(err) {
+			return storage.ErrConflict
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	value.ShortURL, value.DeletedFlag, value.CreatedAt, value.Clicks = shortURL, false, createdAt.Time, prev.Clicks
+	value.UsedClicks = prev.UsedClicks
+	return nil
+}
+
+// urlHistory skips entries older than the URL itself, left behind by an
+// expired URL that used the same code.
+func urlHistory(ctx context.Context, db *sql.DB, d dialect, shortURL string) ([]storage.Change, error) {
+	rows, err := db.QueryContext(ctx, `SELECT h.original_url, h.changed_at FROM url_history h JOIN urls u ON u.short_url = h.short_url
+		WHERE h.short_url = `+d.bind(1)+` AND h.changed_at >= u.created_at ORDER BY h.changed_at`, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	history := make([]storage.Change, 0)
+	for rows.Next() {
+		var change storage.Change
+		if err := rows.Scan(&change.OriginalURL, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		change.ChangedAt = change.ChangedAt.UTC()
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
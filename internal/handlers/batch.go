@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/Yasuhiro-gh/url-shortener/internal/config"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/shortcode"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"github.com/Yasuhiro-gh/url-shortener/internal/utils"
+	"time"
+)
+
+const (
+	BatchStatusCreated  = "created"
+	BatchStatusConflict = "conflict"
+	BatchStatusInvalid  = "invalid"
+)
+
+var (
+	ErrEmptyURL   = errors.New("please provide a url")
+	ErrInvalidURL = errors.New("invalid url")
+)
+
+// batchEntry is one URL of a batch request. Store holds the value to shorten
+// and, once the batch is done, the stored value with the full short URL.
+type batchEntry struct {
+	Store  storage.Store
+	Alias  string
+	Status string
+	Err    error
+}
+
+// newBatchEntry validates the request fields; invalid entries come back
+// already resolved and are skipped by shortenBatch.
+func newBatchEntry(originalURL string, alias string, expiresAt *time.Time, ttlSeconds int64, opts linkOptions, userID int) *batchEntry {
+	entry := &batchEntry{Store: storage.Store{OriginalURL: originalURL, UserID: userID}, Alias: alias}
+	switch {
+	case originalURL == "":
+		entry.Err = ErrEmptyURL
+	case !utils.IsValidURL(originalURL):
+		entry.Err = ErrInvalidURL
+	case alias != "":
+		entry.Err = shortcode.ValidateAlias(alias)
+	}
+	if entry.Err == nil {
+		entry.Store.ExpiresAt, entry.Err = parseExpiry(expiresAt, ttlSeconds)
+	}
+	if entry.Err == nil {
+		entry.Err = opts.apply(&entry.Store)
+	}
+	if entry.Err != nil {
+		entry.Status = BatchStatusInvalid
+	}
+	return entry
+}
+
+// shortenBatch stores every valid entry with a single storage batch. Because
+// a batch with a colliding code is not stored at all, it is retried with new
+// codes for the collided entries; taken aliases are reported as conflicts,
+// as /api/shorten answers them with 409 Conflict.
+func (h *URLHandler) shortenBatch(ctx context.Context, entries []*batchEntry) error {
+	var pending []int
+	for i, entry := range entries {
+		if entry.Status == "" {
+			pending = append(pending, i)
+		}
+	}
+
+	attempts := make([]int, len(entries))
+	for round := 0; len(pending) > 0; round++ {
+		if round >= config.Options.ShortCodeMaxAttempts {
+			return ErrShortCodeExhausted
+		}
+
+		items := make([]storage.BatchItem, len(pending))
+		for n, i := range pending {
+			code := entries[i].Alias
+			if code == "" {
+				var err error
+				code, err = h.generator.Generate(ctx, entries[i].Store.OriginalURL, attempts[i])
+				if err != nil {
+					return err
+				}
+			}
+			item := storage.BatchItem{ShortURL: code, Store: entries[i].Store}
+			item.Store.ShortURL = config.Options.BaseURL + "/" + code
+			items[n] = item
+		}
+
+		if err := h.SetBatch(ctx, items); err != nil {
+			return err
+		}
+
+		collided := false
+		retry := pending[:0:0]
+		for n, i := range pending {
+			if errors.Is(items[n].Err, storage.ErrCodeCollision) {
+				collided = true
+				if entries[i].Alias != "" {
+					entries[i].Status, entries[i].Err = BatchStatusConflict, ErrAliasTaken
+					continue
+				}
+				attempts[i]++
+			}
+			retry = append(retry, i)
+		}
+		if collided {
+			pending = retry
+			continue
+		}
+
+		for n, i := range pending {
+			urlStore, err := stored(&items[n].Store, items[n].Err)
+			entries[i].Store = *urlStore
+			entries[i].Status = BatchStatusCreated
+			if errors.Is(err, storage.ErrConflict) {
+				entries[i].Status = BatchStatusConflict
+			}
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Yasuhiro-gh/url-shortener/internal/config"
+	"github.com/Yasuhiro-gh/url-shortener/internal/logger"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeCSV    = "text/csv"
+
+	// BatchStatusFailed marks lines a bulk import could not finish, e.g.
+	// because the storage went down midway.
+	BatchStatusFailed = "failed"
+)
+
+var csvColumns = []string{"line", "correlation_id", "short_url", "status", "error"}
+
+// bulkLine is one URL of a bulk import, with the same fields as a batch item.
+type bulkLine struct {
+	CorrelationID string     `json:"correlation_id"`
+	OriginalURL   string     `json:"original_url"`
+	Alias         string     `json:"alias,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	TTLSeconds    int64      `json:"ttl_seconds,omitempty"`
+	linkOptions
+
+	line int
+}
+
+type bulkResult struct {
+	Line          int    `json:"line"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	ShortURL      string `json:"short_url,omitempty"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+}
+
+// invalidLineError is returned for a line that cannot be parsed; reading
+// continues with the next one.
+type invalidLineError struct {
+	err error
+}
+
+func (e *invalidLineError) Error() string {
+	return e.err.Error()
+}
+
+// bulkReader reads a bulk import body line by line, returning io.EOF at its
+// end.
+type bulkReader interface {
+	Read() (bulkLine, error)
+}
+
+type ndjsonReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func newNDJSONReader(body io.Reader) *ndjsonReader {
+	return &ndjsonReader{r: bufio.NewReader(body)}
+}
+
+func (nr *ndjsonReader) Read() (bulkLine, error) {
+	for {
+		data, err := nr.r.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) == 0 {
+			if err != nil {
+				return bulkLine{}, err
+			}
+			nr.line++
+			continue
+		}
+		nr.line++
+
+		bl := bulkLine{line: nr.line}
+		if err := json.Unmarshal(data, &bl); err != nil {
+			return bulkLine{line: nr.line}, &invalidLineError{err}
+		}
+		return bl, nil
+	}
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+// newCSVReader reads the header, which must name an original_url column and
+// may name correlation_id, alias, expires_at, ttl_seconds, redirect_type,
+// forward_query, forward_path, password and max_clicks.
+func newCSVReader(body io.Reader) (*csvReader, error) {
+	r := csv.NewReader(body)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["original_url"]; !ok {
+		return nil, errors.New("csv header must name an original_url column")
+	}
+	return &csvReader{r: r, columns: columns}, nil
+}
+
+func (cr *csvReader) field(record []string, name string) string {
+	i, ok := cr.columns[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+func (cr *csvReader) Read() (bulkLine, error) {
+	record, err := cr.r.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return bulkLine{line: parseErr.StartLine}, &invalidLineError{err}
+		}
+		return bulkLine{}, err
+	}
+	line, _ := cr.r.FieldPos(0)
+
+	bl := bulkLine{
+		CorrelationID: cr.field(record, "correlation_id"),
+		OriginalURL:   cr.field(record, "original_url"),
+		Alias:         cr.field(record, "alias"),
+		line:          line,
+	}
+	if ttl := cr.field(record, "ttl_seconds"); ttl != "" {
+		if bl.TTLSeconds, err = strconv.ParseInt(ttl, 10, 64); err != nil {
+			return bl, &invalidLineError{ErrInvalidExpiry}
+		}
+	}
+	if expiresAt := cr.field(record, "expires_at"); expiresAt != "" {
+		t, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return bl, &invalidLineError{ErrInvalidExpiry}
+		}
+		bl.ExpiresAt = &t
+	}
+	if redirectType := cr.field(record, "redirect_type"); redirectType != "" {
+		if bl.RedirectType, err = strconv.Atoi(redirectType); err != nil {
+			return bl, &invalidLineError{ErrInvalidRedirectType}
+		}
+	}
+	if forwardQuery := cr.field(record, "forward_query"); forwardQuery != "" {
+		if bl.ForwardQuery, err = strconv.ParseBool(forwardQuery); err != nil {
+			return bl, &invalidLineError{ErrInvalidForward}
+		}
+	}
+	if forwardPath := cr.field(record, "forward_path"); forwardPath != "" {
+		if bl.ForwardPath, err = strconv.ParseBool(forwardPath); err != nil {
+			return bl, &invalidLineError{ErrInvalidForward}
+		}
+	}
+	bl.Password = cr.field(record, "password")
+	if maxClicks := cr.field(record, "max_clicks"); maxClicks != "" {
+		if bl.MaxClicks, err = strconv.ParseInt(maxClicks, 10, 64); err != nil {
+			return bl, &invalidLineError{ErrInvalidMaxClicks}
+		}
+	}
+	return bl, nil
+}
+
+// bulkWriter streams results back in the format of the request.
+type bulkWriter struct {
+	json *json.Encoder
+	csv  *csv.Writer
+}
+
+func newBulkWriter(w io.Writer, mediaType string) *bulkWriter {
+	if mediaType == contentTypeCSV {
+		cw := csv.NewWriter(w)
+		_ = cw.Write(csvColumns)
+		return &bulkWriter{csv: cw}
+	}
+	return &bulkWriter{json: json.NewEncoder(w)}
+}
+
+func (bw *bulkWriter) Write(res bulkResult) error {
+	if bw.csv != nil {
+		return bw.csv.Write([]string{strconv.Itoa(res.Line), res.CorrelationID, res.ShortURL, res.Status, res.Error})
+	}
+	return bw.json.Encode(res)
+}
+
+func (bw *bulkWriter) Flush() error {
+	if bw.csv != nil {
+		bw.csv.Flush()
+		return bw.csv.Error()
+	}
+	return nil
+}
+
+func (h *URLHandler) ShortenBulk() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is supported.", http.StatusBadRequest)
+			return
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var reader bulkReader
+		switch mediaType {
+		case contentTypeNDJSON:
+			reader = newNDJSONReader(r.Body)
+		case contentTypeCSV:
+			cr, err := newCSVReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			reader = cr
+		default:
+			http.Error(w, "Only NDJSON and CSV content types are supported.", http.StatusBadRequest)
+			return
+		}
+
+		userID, _ := h.Auth(w, r)
+		if uid, err := GetUserIDFromCookie(r); err == nil {
+			userID = uid
+		}
+
+		// Results are flushed while the body is still being read, which an
+		// HTTP/1.1 server only allows in full duplex mode; HTTP/2 always is.
+		rc := http.NewResponseController(w)
+		if err := rc.EnableFullDuplex(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", mediaType)
+		w.WriteHeader(http.StatusOK)
+		results := newBulkWriter(w, mediaType)
+
+		chunkSize := max(config.Options.BulkChunkSize, 1)
+		counts := make(map[string]int)
+		lines := 0
+		var fatal error
+		for done := false; !done; {
+			// Every chunk gets a fresh deadline, so a long import is not cut
+			// off by the server timeouts as long as it keeps progressing.
+			_ = rc.SetReadDeadline(time.Now().Add(config.Options.ReadTimeout))
+			_ = rc.SetWriteDeadline(time.Now().Add(config.Options.WriteTimeout))
+
+			chunk := make([]bulkLine, 0, chunkSize)
+			entries := make([]*batchEntry, 0, chunkSize)
+			for len(chunk) < chunkSize {
+				bl, err := reader.Read()
+				var invalid *invalidLineError
+				if err != nil && !errors.As(err, &invalid) {
+					if !errors.Is(err, io.EOF) {
+						fatal = err
+					}
+					done = true
+					break
+				}
+				if lines >= config.Options.BulkMaxLines {
+					fatal = fmt.Errorf("bulk import is limited to %d urls", config.Options.BulkMaxLines)
+					done = true
+					break
+				}
+				lines++
+
+				chunk = append(chunk, bl)
+				if invalid != nil {
+					entries = append(entries, &batchEntry{Status: BatchStatusInvalid, Err: invalid.err})
+					continue
+				}
+				entries = append(entries, newBatchEntry(bl.OriginalURL, bl.Alias, bl.ExpiresAt, bl.TTLSeconds, bl.linkOptions, userID))
+			}
+
+			if err := h.shortenBatch(r.Context(), entries); err != nil {
+				fatal = err
+				done = true
+				for _, entry := range entries {
+					if entry.Status == "" {
+						entry.Status, entry.Err = BatchStatusFailed, err
+					}
+				}
+			}
+
+			for i, entry := range entries {
+				res := bulkResult{Line: chunk[i].line, CorrelationID: chunk[i].CorrelationID, ShortURL: entry.Store.ShortURL, Status: entry.Status}
+				if entry.Err != nil {
+					res.Error = entry.Err.Error()
+				}
+				counts[entry.Status]++
+				if err := results.Write(res); err != nil {
+					return
+				}
+			}
+			if err := results.Flush(); err != nil {
+				return
+			}
+			_ = rc.Flush()
+
+			if len(entries) > 0 {
+				logger.Infoln("bulk", "user", userID, "lines", lines, "created", counts[BatchStatusCreated],
+					"conflict", counts[BatchStatusConflict], "invalid", counts[BatchStatusInvalid])
+			}
+		}
+
+		if fatal != nil {
+			logger.Errorln("bulk", "user", userID, "lines", lines, "error", fatal)
+			_ = results.Write(bulkResult{Status: BatchStatusFailed, Error: fatal.Error()})
+			_ = results.Flush()
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/Yasuhiro-gh/url-shortener/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestShortenBulkNDJSON(t *testing.T) {
+	defer func(chunkSize, maxLines int) {
+		config.Options.BulkChunkSize, config.Options.BulkMaxLines = chunkSize, maxLines
+	}(config.Options.BulkChunkSize, config.Options.BulkMaxLines)
+	config.Options.BulkChunkSize = 2
+	config.Options.BulkMaxLines = 100
+
+	var body strings.Builder
+	for i := 0; i < 5; i++ {
+		body.WriteString(`{"correlation_id": "` + strconv.Itoa(i) + `", "original_url": "https://yandex.com/` + strconv.Itoa(i) + `"}` + "\n")
+	}
+	body.WriteString("\n{broken\n")
+	body.WriteString(`{"correlation_id": "repeated", "original_url": "https://yandex.com/0"}`)
+
+	us := NewMockMapURLS()
+	r := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten/bulk", strings.NewReader(body.String()))
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+
+	newTestHandler(us).ShortenBulk().ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code, "Wrong response code status")
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	var results []bulkResult
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var res bulkResult
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &res))
+		results = append(results, res)
+	}
+	require.Len(t, results, 7)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, BatchStatusCreated, results[i].Status)
+		assert.Equal(t, i+1, results[i].Line)
+	}
+	assert.Equal(t, bulkResult{Line: 7, Status: BatchStatusInvalid, Error: results[5].Error}, results[5])
+	assert.Equal(t, BatchStatusConflict, results[6].Status)
+	assert.Equal(t, 8, results[6].Line)
+	assert.Equal(t, results[0].ShortURL, results[6].ShortURL)
+}
+
+func TestShortenBulkStreamsOverHTTP(t *testing.T) {
+	defer func(chunkSize int) { config.Options.BulkChunkSize = chunkSize }(config.Options.BulkChunkSize)
+	config.Options.BulkChunkSize = 10
+
+	srv := httptest.NewServer(newTestHandler(NewMockMapURLS()).ShortenBulk())
+	defer srv.Close()
+
+	const lines = 3000
+	var body strings.Builder
+	for i := 0; i < lines; i++ {
+		body.WriteString(`{"original_url": "https://yandex.com/` + strconv.Itoa(i) + `"}` + "\n")
+	}
+	resp, err := http.Post(srv.URL, "application/x-ndjson", strings.NewReader(body.String()))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Wrong response code status")
+
+	created := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var res bulkResult
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &res))
+		require.Equal(t, BatchStatusCreated, res.Status, "Line %d: %s", res.Line, res.Error)
+		created++
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, lines, created, "Every line must be read after the first results are flushed")
+}
+
+func TestShortenBulkCSV(t *testing.T) {
+	defer func(maxLines int) { config.Options.BulkMaxLines = maxLines }(config.Options.BulkMaxLines)
+	config.Options.BulkMaxLines = 2
+
+	body := "correlation_id,original_url,ttl_seconds\n" +
+		"a,https://yandex.com,60\n" +
+		"b,not a url,\n" +
+		"c,https://ya.ru,\n"
+	r := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten/bulk", strings.NewReader(body))
+	r.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	w := httptest.NewRecorder()
+
+	newTestHandler(NewMockMapURLS()).ShortenBulk().ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code, "Wrong response code status")
+	records, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.Equal(t, csvColumns, records[0])
+	assert.Equal(t, []string{"2", "a", BatchStatusCreated}, []string{records[1][0], records[1][1], records[1][3]})
+	assert.Equal(t, []string{"3", "b", BatchStatusInvalid}, []string{records[2][0], records[2][1], records[2][3]})
+	assert.Equal(t, BatchStatusFailed, records[3][3], "Lines over the limit must be refused")
+}
+
+func TestShortenBulkBadRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{name: "json array", contentType: "application/json", body: `[]`},
+		{name: "csv without url column", contentType: "text/csv", body: "correlation_id,url\n1,https://yandex.com\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten/bulk", strings.NewReader(test.body))
+			r.Header.Set("Content-Type", test.contentType)
+			w := httptest.NewRecorder()
+
+			newTestHandler(NewMockMapURLS()).ShortenBulk().ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, "Wrong response code status")
+		})
+	}
+}
@@ -9,39 +9,62 @@ import (
 	"github.com/Yasuhiro-gh/url-shortener/internal/config"
 	"github.com/Yasuhiro-gh/url-shortener/internal/db"
 	"github.com/Yasuhiro-gh/url-shortener/internal/logger"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/analytics"
 	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/compress"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/deleter"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/identity"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/ratelimit"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/shortcode"
 	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
-	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage/filestore"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage/cache"
 	"github.com/Yasuhiro-gh/url-shortener/internal/utils"
 	"github.com/go-chi/chi/v5"
-	"github.com/jackc/pgerrcode"
 	"io"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 )
 
+var ErrShortCodeExhausted = errors.New("could not generate a free short code")
+var ErrAliasTaken = errors.New("alias is already taken")
+var ErrUnauthorized = errors.New("unauthorized")
+var ErrInvalidExpiry = errors.New("expires_at must be in the future and ttl_seconds positive, only one of them is allowed")
+
 type URLHandler struct {
 	storage.URLStorages
+	generator shortcode.Generator
+	clicks    *analytics.Pipeline
+	users     identity.UserIDAllocator
+	deleter   *deleter.Deleter
+	guesses   *ratelimit.Limiter
 }
 
-func NewURLHandler(us *storage.URLS) *URLHandler {
-	return &URLHandler{us}
+func NewURLHandler(us *storage.URLS, gen shortcode.Generator, clicks *analytics.Pipeline, users identity.UserIDAllocator, del *deleter.Deleter) *URLHandler {
+	return &URLHandler{URLStorages: us, generator: gen, clicks: clicks, users: users, deleter: del,
+		guesses: ratelimit.NewLimiter(config.Options.PasswordMaxAttempts, config.Options.PasswordWindow)}
 }
 
-func URLRouter(ctx context.Context, us *storage.URLS, pdb *db.PostgresDB) chi.Router {
+func URLRouter(ctx context.Context, us *storage.URLS, pinger db.Pinger, gen shortcode.Generator, clicks *analytics.Pipeline, users identity.UserIDAllocator, del *deleter.Deleter) chi.Router {
 	r := chi.NewRouter()
 
-	uh := NewURLHandler(us)
+	uh := NewURLHandler(us, gen, clicks, users, del)
 
 	r.Handle("/", gzipMiddleware(logger.Logging(uh.ShortURL())))
 	r.Handle("/{id}", gzipMiddleware(logger.Logging(uh.GetShortURL())))
+	r.Handle("/{id}/*", gzipMiddleware(logger.Logging(uh.GetShortURL())))
+	r.Post("/{id}", gzipMiddleware(logger.Logging(uh.UnlockShortURL())))
+	r.Post("/{id}/*", gzipMiddleware(logger.Logging(uh.UnlockShortURL())))
 	r.Handle("/api/shorten", gzipMiddleware(logger.Logging(uh.ShortURLJSON())))
 	r.Handle("/api/shorten/batch", gzipMiddleware(logger.Logging(uh.ShortURLBatch())))
+	r.Post("/api/shorten/bulk", gzipMiddleware(logger.Logging(uh.ShortenBulk())))
 	r.Get("/api/user/urls", gzipMiddleware(logger.Logging(uh.UserURLS())))
 	r.Delete("/api/user/urls", gzipMiddleware(logger.Logging(uh.DeleteUserURLS())))
-	r.Handle("/ping", logger.Logging(CheckDBConnection(ctx, pdb)))
+	r.Get("/api/user/urls/trash", gzipMiddleware(logger.Logging(uh.UserURLSTrash())))
+	r.Post("/api/user/urls/restore", gzipMiddleware(logger.Logging(uh.RestoreUserURLS())))
+	r.Patch("/api/user/urls/{id}", gzipMiddleware(logger.Logging(uh.UpdateUserURL())))
+	r.Get("/api/user/urls/{id}/stats", gzipMiddleware(logger.Logging(uh.URLStats())))
+	r.Get("/api/user/urls/{id}/history", gzipMiddleware(logger.Logging(uh.UserURLHistory())))
+	r.Handle("/ping", logger.Logging(CheckDBConnection(ctx, pinger)))
 	return r
 }
 
@@ -79,6 +102,9 @@ func gzipMiddleware(next http.Handler) http.HandlerFunc {
 	}
 }
 
+// Auth returns the user of the request, issuing a new identity with its
+// cookie to clients without one. Only endpoints that create links call it;
+// everything else checks the cookie with authenticated.
 func (h *URLHandler) Auth(w http.ResponseWriter, r *http.Request) (int, error) {
 	cookie, cookieErr := r.Cookie("userIDToken")
 
@@ -89,15 +115,113 @@ func (h *URLHandler) Auth(w http.ResponseWriter, r *http.Request) (int, error) {
 		}
 	}
 
-	newUserID := h.GetUserID()
-	newUserID++
+	newUserID, err := h.users.NextUserID(r.Context())
+	if err != nil {
+		return 0, err
+	}
 	token, err := auth.BuildJWTString(newUserID)
 	if err != nil {
 		return newUserID, err
 	}
 	newCookie := http.Cookie{Name: "userIDToken", Value: token, Expires: time.Now().Add(time.Minute * 10), Path: "/"}
 	http.SetCookie(w, &newCookie)
-	return newUserID, errors.New("unauthorized")
+	return newUserID, ErrUnauthorized
+}
+
+// authenticated returns the user of the request without issuing an identity.
+func authenticated(r *http.Request) (int, error) {
+	userID, err := GetUserIDFromCookie(r)
+	if err != nil {
+		return 0, ErrUnauthorized
+	}
+	return userID, nil
+}
+
+// storageStatus maps storage errors to response codes; untyped errors mean
+// the backend is unavailable.
+func storageStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrDeleted), errors.Is(err, storage.ErrClickLimit):
+		return http.StatusGone
+	case errors.Is(err, storage.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrShortCodeExhausted):
+		return http.StatusInternalServerError
+	}
+	return http.StatusServiceUnavailable
+}
+
+func (h *URLHandler) shorten(ctx context.Context, proto storage.Store, alias string) (*storage.Store, error) {
+	if alias != "" {
+		return h.shortenWithAlias(ctx, proto, alias)
+	}
+	for attempt := 0; attempt < config.Options.ShortCodeMaxAttempts; attempt++ {
+		code, err := h.generator.Generate(ctx, proto.OriginalURL, attempt)
+		if err != nil {
+			return nil, err
+		}
+		urlStore := proto
+		urlStore.ShortURL = config.Options.BaseURL + "/" + code
+		err = h.Set(ctx, code, &urlStore)
+		if errors.Is(err, storage.ErrCodeCollision) {
+			continue
+		}
+		return stored(&urlStore, err)
+	}
+	return nil, ErrShortCodeExhausted
+}
+
+func (h *URLHandler) shortenWithAlias(ctx context.Context, proto storage.Store, alias string) (*storage.Store, error) {
+	if err := shortcode.ValidateAlias(alias); err != nil {
+		return nil, err
+	}
+	_, err := h.Get(ctx, alias)
+	switch {
+	case err == nil, errors.Is(err, storage.ErrDeleted):
+		return nil, ErrAliasTaken
+	case !errors.Is(err, storage.ErrNotFound):
+		return nil, err
+	}
+	urlStore := proto
+	urlStore.ShortURL = config.Options.BaseURL + "/" + alias
+	err = h.Set(ctx, alias, &urlStore)
+	if errors.Is(err, storage.ErrCodeCollision) {
+		return nil, ErrAliasTaken
+	}
+	return stored(&urlStore, err)
+}
+
+// stored swaps the new value for the one already in storage on conflict, so
+// the response carries the short URL that actually works.
+func stored(urlStore *storage.Store, err error) (*storage.Store, error) {
+	var conflict *storage.ConflictError
+	if errors.As(err, &conflict) {
+		existing := conflict.Existing
+		existing.ShortURL = config.Options.BaseURL + "/" + existing.ShortURL
+		return &existing, err
+	}
+	return urlStore, err
+}
+
+func parseExpiry(expiresAt *time.Time, ttlSeconds int64) (time.Time, error) {
+	switch {
+	case expiresAt != nil && ttlSeconds != 0:
+		return time.Time{}, ErrInvalidExpiry
+	case expiresAt != nil:
+		if !expiresAt.After(time.Now()) {
+			return time.Time{}, ErrInvalidExpiry
+		}
+		return expiresAt.UTC(), nil
+	case ttlSeconds < 0:
+		return time.Time{}, ErrInvalidExpiry
+	case ttlSeconds > 0:
+		return time.Now().Add(time.Duration(ttlSeconds) * time.Second).UTC(), nil
+	}
+	return time.Time{}, nil
 }
 
 func (h *URLHandler) ShortURL() http.HandlerFunc {
@@ -128,17 +252,12 @@ func (h *URLHandler) ShortURL() http.HandlerFunc {
 
 		var httpStatus = http.StatusCreated
 
-		urlHash := utils.HashURL(urlString)
-		urlStore := &storage.Store{OriginalURL: urlString, ShortURL: config.Options.BaseURL + "/" + urlHash, UserID: userID}
-
-		repeatErr := h.Set(urlHash, urlStore)
-		if repeatErr != nil && repeatErr.Error() == pgerrcode.UniqueViolation {
+		urlStore, repeatErr := h.shorten(r.Context(), storage.Store{OriginalURL: urlString, UserID: userID}, "")
+		if errors.Is(repeatErr, storage.ErrConflict) {
 			httpStatus = http.StatusConflict
-		} else {
-			repeatErr = filestore.MakeRecord(urlStore)
-			if repeatErr != nil {
-				http.Error(w, repeatErr.Error(), http.StatusBadRequest)
-			}
+		} else if repeatErr != nil {
+			http.Error(w, repeatErr.Error(), storageStatus(repeatErr))
+			return
 		}
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(httpStatus)
@@ -153,63 +272,95 @@ func (h *URLHandler) GetShortURL() http.HandlerFunc {
 			return
 		}
 
-		_, _ = h.Auth(w, r)
-
-		shortURL := r.PathValue("id")
-
-		if shortURL == "" {
-			http.Error(w, "Please provide a URL.", http.StatusBadRequest)
+		now := time.Now()
+		shortURL, urlStore, location, ok := h.visit(w, r, now)
+		if !ok {
 			return
 		}
-
-		urlStore, exist := h.Get(shortURL)
-		if !exist {
-			http.Error(w, "Invalid URL.", http.StatusBadRequest)
+		if urlStore.PasswordHash != "" {
+			passwordForm(w, http.StatusOK, "")
 			return
 		}
-
-		if urlStore.DeletedFlag {
-			http.Error(w, "Short URL already deleted.", http.StatusGone)
+		if !h.useClick(w, r, shortURL, urlStore) {
 			return
 		}
 
-		w.Header().Set("Content-Type", "text/plain")
-		w.Header().Set("Location", urlStore.OriginalURL)
-		w.WriteHeader(http.StatusTemporaryRedirect)
+		h.clicks.Track(analytics.NewClick(r, shortURL))
+
+		redirect(w, urlStore, location, now)
+	}
+}
+
+// useClick takes one of the redirects of a click limited link; the cached
+// UsedClicks checked by visit may be stale, the storage decides.
+func (h *URLHandler) useClick(w http.ResponseWriter, r *http.Request, shortURL string, urlStore storage.Store) bool {
+	if urlStore.MaxClicks == 0 {
+		return true
+	}
+	if _, err := h.UseClick(r.Context(), shortURL); err != nil {
+		http.Error(w, err.Error(), storageStatus(err))
+		return false
 	}
+	return true
 }
 
-func (h *URLHandler) UserURLS() http.HandlerFunc {
+// visit loads the link r is visiting and its redirect location, answering
+// with the error if the link cannot be followed.
+func (h *URLHandler) visit(w http.ResponseWriter, r *http.Request, now time.Time) (string, storage.Store, string, bool) {
+	shortURL := r.PathValue("id")
+
+	if shortURL == "" {
+		http.Error(w, "Please provide a URL.", http.StatusBadRequest)
+		return "", storage.Store{}, "", false
+	}
+
+	urlStore, err := h.Get(r.Context(), shortURL)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		http.Error(w, "Invalid URL.", http.StatusNotFound)
+		return "", storage.Store{}, "", false
+	case errors.Is(err, storage.ErrDeleted):
+		http.Error(w, "Short URL already deleted.", http.StatusGone)
+		return "", storage.Store{}, "", false
+	case err != nil:
+		http.Error(w, err.Error(), storageStatus(err))
+		return "", storage.Store{}, "", false
+	}
+
+	if urlStore.IsExpired(now) {
+		http.Error(w, "Short URL expired.", http.StatusGone)
+		return "", storage.Store{}, "", false
+	}
+	if urlStore.MaxClicks > 0 && urlStore.UsedClicks >= urlStore.MaxClicks {
+		http.Error(w, "Short URL click limit reached.", http.StatusGone)
+		return "", storage.Store{}, "", false
+	}
+
+	location, ok := destination(urlStore, r)
+	if !ok {
+		http.Error(w, "Invalid URL.", http.StatusNotFound)
+		return "", storage.Store{}, "", false
+	}
+	return shortURL, urlStore, location, true
+}
+
+func (h *URLHandler) URLStats() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userID, err := h.Auth(w, r)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+		shortURL, _, ok := h.ownedURL(w, r)
+		if !ok {
 			return
 		}
 
-		urlStores, err := h.GetUserURLS(r.Context(), userID)
+		stats, err := h.clicks.Stats(r.Context(), shortURL)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		if len(urlStores) == 0 {
-			http.Error(w, "There is no your urls", http.StatusNoContent)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		type userURL struct {
-			ShortURL    string `json:"short_url"`
-			OriginalURL string `json:"original_url"`
-		}
-		var userURLS []userURL
-		for _, u := range urlStores {
-			url := userURL{config.Options.BaseURL + "/" + u.ShortURL, u.OriginalURL}
-			userURLS = append(userURLS, url)
-		}
-
-		resp, err := json.Marshal(userURLS)
+		resp, err := json.Marshal(stats)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -224,13 +375,14 @@ func (h *URLHandler) DeleteUserURLS() http.HandlerFunc {
 			return
 		}
 
-		userID, _ := h.Auth(w, r)
-		if uid, err := GetUserIDFromCookie(r); err == nil {
-			userID = uid
+		userID, err := authenticated(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
 		}
 
 		var buf bytes.Buffer
-		_, err := buf.ReadFrom(r.Body)
+		_, err = buf.ReadFrom(r.Body)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -239,88 +391,15 @@ func (h *URLHandler) DeleteUserURLS() http.HandlerFunc {
 		var shortURLS []string
 		if err := json.Unmarshal(buf.Bytes(), &shortURLS); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		var mu sync.RWMutex
-		var wg sync.WaitGroup
-
-		doneCh := make(chan struct{})
-
-		defer close(doneCh)
-
-		generator := func(doneCh chan struct{}, input []string) chan string {
-			inputCh := make(chan string)
-
-			go func() {
-				defer close(inputCh)
-
-				for _, shortURL := range input {
-					select {
-					case <-doneCh:
-						return
-					case inputCh <- shortURL:
-					}
-				}
-			}()
-
-			return inputCh
-		}
-
-		fanOut := func(doneCh chan struct{}, inputCh chan string) []chan string {
-			numWorkers := 5
-			channels := make([]chan string, numWorkers)
-
-			for i := 0; i < numWorkers; i++ {
-				channels[i] = inputCh
-			}
-
-			return channels
-		}
-
-		fanIn := func(doneCh chan struct{}, deletedChs ...chan string) chan string {
-			finalCh := make(chan string)
-
-			for _, ch := range deletedChs {
-				chClosure := ch
-
-				wg.Add(1)
-
-				go func() {
-					defer wg.Done()
-
-					for data := range chClosure {
-						select {
-						case <-doneCh:
-							return
-						case finalCh <- data:
-						}
-					}
-				}()
-			}
-
-			go func() {
-				wg.Wait()
-				close(finalCh)
-			}()
-
-			return finalCh
-		}
-
-		input := generator(doneCh, shortURLS)
-
-		channels := fanOut(doneCh, input)
-
-		urlsCh := fanIn(doneCh, channels...)
-
-		for url := range urlsCh {
-			mu.Lock()
-			err := h.Delete(url, userID)
+		if len(shortURLS) > 0 {
+			err = h.deleter.Enqueue(r.Context(), deleter.Job{UserID: userID, ShortURLs: shortURLS})
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				mu.Unlock()
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
 				return
 			}
-			mu.Unlock()
 		}
 
 		w.WriteHeader(http.StatusAccepted)
@@ -348,7 +427,11 @@ func (h *URLHandler) ShortURLJSON() http.HandlerFunc {
 		var buf bytes.Buffer
 
 		type ShortenJSON struct {
-			URL string `json:"url"`
+			URL        string     `json:"url"`
+			Alias      string     `json:"alias,omitempty"`
+			ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+			TTLSeconds int64      `json:"ttl_seconds,omitempty"`
+			linkOptions
 		}
 
 		var shortenRequest ShortenJSON
@@ -380,27 +463,41 @@ func (h *URLHandler) ShortURLJSON() http.HandlerFunc {
 			return
 		}
 
-		urlHash := utils.HashURL(shortenRequest.URL)
-		urlStore := &storage.Store{OriginalURL: shortenRequest.URL, ShortURL: config.Options.BaseURL + "/" + urlHash, UserID: userID}
-		repeatErr := h.Set(urlHash, urlStore)
+		expiresAt, err := parseExpiry(shortenRequest.ExpiresAt, shortenRequest.TTLSeconds)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		proto := storage.Store{OriginalURL: shortenRequest.URL, UserID: userID, ExpiresAt: expiresAt}
+		if err := shortenRequest.apply(&proto); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		urlStore, repeatErr := h.shorten(r.Context(), proto, shortenRequest.Alias)
 		var httpStatus = http.StatusCreated
+		switch {
+		case errors.Is(repeatErr, shortcode.ErrInvalidAlias):
+			http.Error(w, repeatErr.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(repeatErr, ErrAliasTaken):
+			http.Error(w, repeatErr.Error(), http.StatusConflict)
+			return
+		case errors.Is(repeatErr, storage.ErrConflict):
+			httpStatus = http.StatusConflict
+		case repeatErr != nil:
+			http.Error(w, repeatErr.Error(), storageStatus(repeatErr))
+			return
+		}
 
-		shortenResponse.Result = config.Options.BaseURL + "/" + urlHash
+		shortenResponse.Result = urlStore.ShortURL
 
 		resp, err := json.Marshal(shortenResponse)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 		}
 
-		if repeatErr != nil && repeatErr.Error() == pgerrcode.UniqueViolation {
-			httpStatus = http.StatusConflict
-		} else {
-			err = filestore.MakeRecord(urlStore)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-			}
-		}
-
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(httpStatus)
 		_, _ = w.Write(resp)
@@ -411,6 +508,7 @@ func (h *URLHandler) ShortURLBatch() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Only POST method is supported.", http.StatusBadRequest)
+			return
 		}
 
 		if !strings.Contains(r.Header.Get("Content-Type"), "application/json") {
@@ -427,8 +525,12 @@ func (h *URLHandler) ShortURLBatch() http.HandlerFunc {
 		var buf bytes.Buffer
 
 		type ShortenJSON struct {
-			CorrelationID string `json:"correlation_id"`
-			OriginalURL   string `json:"original_url"`
+			CorrelationID string     `json:"correlation_id"`
+			OriginalURL   string     `json:"original_url"`
+			Alias         string     `json:"alias,omitempty"`
+			ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+			TTLSeconds    int64      `json:"ttl_seconds,omitempty"`
+			linkOptions
 		}
 
 		var shortenRequest []ShortenJSON
@@ -443,42 +545,30 @@ func (h *URLHandler) ShortURLBatch() http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		type ShortenResponse struct {
-			CorrelationID string `json:"correlation_id"`
-			ShortURL      string `json:"short_url"`
-		}
 
-		var shortenResponse []ShortenResponse
-
-		var httpStatus = http.StatusCreated
+		entries := make([]*batchEntry, len(shortenRequest))
+		for i, val := range shortenRequest {
+			entries[i] = newBatchEntry(val.OriginalURL, val.Alias, val.ExpiresAt, val.TTLSeconds, val.linkOptions, userID)
+		}
 
-		for _, val := range shortenRequest {
-			if val.OriginalURL == "" {
-				http.Error(w, "Please provide a URL.", http.StatusBadRequest)
-				return
-			}
+		if err := h.shortenBatch(r.Context(), entries); err != nil {
+			http.Error(w, err.Error(), storageStatus(err))
+			return
+		}
 
-			if !utils.IsValidURL(val.OriginalURL) {
-				http.Error(w, "Invalid URL.", http.StatusBadRequest)
-				return
-			}
+		type ShortenResponse struct {
+			CorrelationID string `json:"correlation_id"`
+			ShortURL      string `json:"short_url,omitempty"`
+			Status        string `json:"status"`
+			Error         string `json:"error,omitempty"`
+		}
 
-			urlHash := utils.HashURL(val.OriginalURL)
-			urlStore := &storage.Store{OriginalURL: val.OriginalURL, ShortURL: config.Options.BaseURL + "/" + urlHash, UserID: userID}
-			repeatErr := h.Set(urlHash, urlStore)
-
-			if repeatErr != nil && repeatErr.Error() == pgerrcode.UniqueViolation {
-				httpStatus = http.StatusConflict
-			} else {
-				err = filestore.MakeRecord(urlStore)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusBadRequest)
-				}
+		shortenResponse := make([]ShortenResponse, len(entries))
+		for i, entry := range entries {
+			shortenResponse[i] = ShortenResponse{CorrelationID: shortenRequest[i].CorrelationID, ShortURL: entry.Store.ShortURL, Status: entry.Status}
+			if entry.Err != nil {
+				shortenResponse[i].Error = entry.Err.Error()
 			}
-
-			response := ShortenResponse{val.CorrelationID, urlStore.ShortURL}
-
-			shortenResponse = append(shortenResponse, response)
 		}
 
 		marshaledResponse, err := json.Marshal(shortenResponse)
@@ -488,12 +578,12 @@ func (h *URLHandler) ShortURLBatch() http.HandlerFunc {
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(httpStatus)
+		w.WriteHeader(http.StatusCreated)
 		_, _ = w.Write(marshaledResponse)
 	}
 }
 
-func CheckDBConnection(ctx context.Context, pdb *db.PostgresDB) http.HandlerFunc {
+func CheckDBConnection(ctx context.Context, pinger db.Pinger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Only GET method is supported.", http.StatusBadRequest)
@@ -501,7 +591,11 @@ func CheckDBConnection(ctx context.Context, pdb *db.PostgresDB) http.HandlerFunc
 
 		ctxTimeout, cancel := context.WithTimeout(ctx, 1*time.Second)
 		defer cancel()
-		if err := pdb.DB.PingContext(ctxTimeout); err != nil {
+		if pinger == nil {
+			http.Error(w, "Cannot connect to database", http.StatusInternalServerError)
+			return
+		}
+		if err := pinger.Ping(ctxTimeout); err != nil {
 			http.Error(w, "Cannot connect to database", http.StatusInternalServerError)
 			return
 		}
@@ -510,3 +604,15 @@ func CheckDBConnection(ctx context.Context, pdb *db.PostgresDB) http.HandlerFunc
 		_, _ = w.Write([]byte("Database connected"))
 	}
 }
+
+func CacheStats(c *cache.Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp, err := json.Marshal(c.Stats())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(resp)
+	}
+}
@@ -1,7 +1,15 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Yasuhiro-gh/url-shortener/internal/auth"
 	"github.com/Yasuhiro-gh/url-shortener/internal/config"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/analytics"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/deleter"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/identity"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/shortcode"
 	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
 	"github.com/Yasuhiro-gh/url-shortener/internal/utils"
 	"github.com/stretchr/testify/assert"
@@ -10,8 +18,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type mockURLS struct {
@@ -21,18 +32,44 @@ type mockURLS struct {
 
 func TestMain(m *testing.M) {
 	config.Run()
-	os.Exit(m.Run())
+	// Keep whatever the tests persist out of the source tree.
+	dir, err := os.MkdirTemp("", "handlers")
+	if err != nil {
+		panic(err)
+	}
+	config.Options.FileStoragePath = filepath.Join(dir, "storage.json")
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
 }
 
 func NewMockMapURLS(urls ...mockURLS) *storage.URLS {
 	us := storage.NewURLStorage()
 	for _, url := range urls {
 		newus := &storage.Store{OriginalURL: url.fullURL, ShortURL: config.Options.BaseURL + "/" + url.shortURL}
-		us.Set(url.shortURL, newus)
+		_ = us.Set(context.Background(), url.shortURL, newus)
 	}
 	return storage.NewURLS(us)
 }
 
+func newTestHandler(us *storage.URLS) *URLHandler {
+	gen, err := shortcode.NewHashGenerator(config.Options.ShortCodeLength)
+	if err != nil {
+		panic(err)
+	}
+	clicks := analytics.NewPipeline(storage.NewClickStorage(), 100, 10, time.Second)
+	lastUserID, err := us.GetUserID(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	users, err := identity.NewCounterAllocator("", lastUserID)
+	if err != nil {
+		panic(err)
+	}
+	del := deleter.NewDeleter(us, 100, 10, time.Second)
+	return NewURLHandler(us, gen, clicks, users, del)
+}
+
 func TestShortURLMethods(t *testing.T) {
 	tests := []struct {
 		storage      *storage.URLS
@@ -70,7 +107,7 @@ func TestShortURLMethods(t *testing.T) {
 			r := httptest.NewRequest(test.method, "http://localhost:8080/", nil)
 			w := httptest.NewRecorder()
 
-			NewURLHandler(test.storage).ShortURL().ServeHTTP(w, r)
+			newTestHandler(test.storage).ShortURL().ServeHTTP(w, r)
 
 			assert.Equal(t, test.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
 		})
@@ -116,7 +153,7 @@ func TestShortURL(t *testing.T) {
 			r := httptest.NewRequest(http.MethodPost, "http://localhost:8080/", strings.NewReader(test.body))
 			w := httptest.NewRecorder()
 
-			NewURLHandler(test.storage).ShortURL().ServeHTTP(w, r)
+			newTestHandler(test.storage).ShortURL().ServeHTTP(w, r)
 
 			res := w.Result()
 
@@ -172,7 +209,7 @@ func TestShortURLJSON(t *testing.T) {
 			r.Header.Set("Content-Type", "application/json")
 			w := httptest.NewRecorder()
 
-			NewURLHandler(test.storage).ShortURLJSON().ServeHTTP(w, r)
+			newTestHandler(test.storage).ShortURLJSON().ServeHTTP(w, r)
 
 			res := w.Result()
 
@@ -231,7 +268,7 @@ func TestGetShortURLMethods(t *testing.T) {
 			r := httptest.NewRequest(test.method, "http://localhost:8080/", nil)
 			w := httptest.NewRecorder()
 
-			NewURLHandler(test.storage).GetShortURL().ServeHTTP(w, r)
+			newTestHandler(test.storage).GetShortURL().ServeHTTP(w, r)
 
 			assert.Equal(t, test.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
 		})
@@ -254,7 +291,7 @@ func TestGetShortURL(t *testing.T) {
 		{
 			name:           "non-existent short url",
 			storage:        NewMockMapURLS(),
-			expectedCode:   http.StatusBadRequest,
+			expectedCode:   http.StatusNotFound,
 			expectedHeader: header{contentType: "text/plain; charset=utf-8"},
 			expectedBody:   "Invalid URL.\n",
 			shortURL:       "12345678",
@@ -273,7 +310,7 @@ func TestGetShortURL(t *testing.T) {
 			r.SetPathValue("id", test.shortURL)
 			w := httptest.NewRecorder()
 
-			NewURLHandler(test.storage).GetShortURL().ServeHTTP(w, r)
+			newTestHandler(test.storage).GetShortURL().ServeHTTP(w, r)
 
 			res := w.Result()
 			defer res.Body.Close()
@@ -284,3 +321,477 @@ func TestGetShortURL(t *testing.T) {
 		})
 	}
 }
+
+type countingUsers struct {
+	issued int
+}
+
+func (cu *countingUsers) NextUserID(ctx context.Context) (int, error) {
+	cu.issued++
+	return cu.issued, nil
+}
+
+func TestOnlyShortenIssuesIdentities(t *testing.T) {
+	code := utils.HashURL("https://yandex.com")
+	h := newTestHandler(NewMockMapURLS(mockURLS{code, "https://yandex.com"}))
+	users := &countingUsers{}
+	h.users = users
+
+	reads := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		body    string
+	}{
+		{name: "redirect", handler: h.GetShortURL(), method: http.MethodGet},
+		{name: "unlock", handler: h.UnlockShortURL(), method: http.MethodPost, body: "password=secret"},
+		{name: "list", handler: h.UserURLS(), method: http.MethodGet},
+		{name: "trash", handler: h.UserURLSTrash(), method: http.MethodGet},
+		{name: "stats", handler: h.URLStats(), method: http.MethodGet},
+	}
+	for _, read := range reads {
+		r := httptest.NewRequest(read.method, "http://localhost:8080/"+code, strings.NewReader(read.body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetPathValue("id", code)
+		w := httptest.NewRecorder()
+
+		read.handler.ServeHTTP(w, r)
+
+		assert.Empty(t, w.Result().Cookies(), read.name)
+	}
+	assert.Zero(t, users.issued, "Requests that create nothing must not allocate user ids")
+
+	r := httptest.NewRequest(http.MethodPost, "http://localhost:8080/", strings.NewReader("https://ya.ru"))
+	w := httptest.NewRecorder()
+	h.ShortURL().ServeHTTP(w, r)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Len(t, w.Result().Cookies(), 1)
+	assert.Equal(t, 1, users.issued)
+}
+
+func TestShortURLCollision(t *testing.T) {
+	originalURL := "https://yandex.com"
+	takenCode := utils.HashURL(originalURL)
+	us := NewMockMapURLS(mockURLS{takenCode, "https://practicum.yandex.ru"})
+
+	r := httptest.NewRequest(http.MethodPost, "http://localhost:8080/", strings.NewReader(originalURL))
+	w := httptest.NewRecorder()
+
+	newTestHandler(us).ShortURL().ServeHTTP(w, r)
+
+	res := w.Result()
+	defer res.Body.Close()
+	resBody, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusCreated, res.StatusCode, "Wrong response code status")
+	newCode := strings.TrimPrefix(string(resBody), config.Options.BaseURL+"/")
+	assert.NotEqual(t, takenCode, newCode, "Collided short code was reused")
+
+	taken, err := us.Get(context.Background(), takenCode)
+	require.NoError(t, err)
+	assert.Equal(t, "https://practicum.yandex.ru", taken.OriginalURL, "Existing short code was overwritten")
+
+	stored, err := us.Get(context.Background(), newCode)
+	require.NoError(t, err)
+	assert.Equal(t, originalURL, stored.OriginalURL)
+}
+
+func TestShortURLJSONAlias(t *testing.T) {
+	tests := []struct {
+		name         string
+		storage      *storage.URLS
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "free alias",
+			storage:      NewMockMapURLS(),
+			body:         `{"url": "https://yandex.com", "alias": "spring-sale"}`,
+			expectedCode: http.StatusCreated,
+			expectedBody: `{"result":"http://localhost:8080/spring-sale"}`,
+		},
+		{
+			name:         "taken alias",
+			storage:      NewMockMapURLS(mockURLS{"spring-sale", "https://practicum.yandex.ru"}),
+			body:         `{"url": "https://yandex.com", "alias": "spring-sale"}`,
+			expectedCode: http.StatusConflict,
+			expectedBody: "alias is already taken\n",
+		},
+		{
+			name:         "reserved alias",
+			storage:      NewMockMapURLS(),
+			body:         `{"url": "https://yandex.com", "alias": "API"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "debug alias",
+			storage:      NewMockMapURLS(),
+			body:         `{"url": "https://yandex.com", "alias": "debug"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "too short alias",
+			storage:      NewMockMapURLS(),
+			body:         `{"url": "https://yandex.com", "alias": "ab"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "bad charset alias",
+			storage:      NewMockMapURLS(),
+			body:         `{"url": "https://yandex.com", "alias": "spring/sale"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten", strings.NewReader(test.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			newTestHandler(test.storage).ShortURLJSON().ServeHTTP(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			resBody, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.expectedCode, res.StatusCode, "Wrong response code status")
+			if test.expectedBody != "" {
+				assert.Equal(t, test.expectedBody, string(resBody), "Wrong response body")
+			}
+		})
+	}
+}
+
+func TestShortURLBatchAlias(t *testing.T) {
+	us := NewMockMapURLS()
+	body := `[{"correlation_id": "1", "original_url": "https://yandex.com", "alias": "spring-sale"},
+		{"correlation_id": "2", "original_url": "https://practicum.yandex.ru"}]`
+
+	r := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten/batch", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	newTestHandler(us).ShortURLBatch().ServeHTTP(w, r)
+
+	res := w.Result()
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusCreated, res.StatusCode, "Wrong response code status")
+	stored, err := us.Get(context.Background(), "spring-sale")
+	require.NoError(t, err)
+	assert.Equal(t, "https://yandex.com", stored.OriginalURL)
+}
+
+func TestShortURLBatchAliasRepeated(t *testing.T) {
+	us := NewMockMapURLS()
+	body := `[{"correlation_id": "1", "original_url": "https://yandex.com", "alias": "same"},
+		{"correlation_id": "2", "original_url": "https://ya.ru", "alias": "same"},
+		{"correlation_id": "3", "original_url": "https://practicum.yandex.ru"}]`
+
+	r := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten/batch", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	newTestHandler(us).ShortURLBatch().ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusCreated, w.Code, "Wrong response code status")
+	var results []struct {
+		Status string `json:"status"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	require.Len(t, results, 3)
+	assert.Equal(t, []string{BatchStatusCreated, BatchStatusConflict, BatchStatusCreated},
+		[]string{results[0].Status, results[1].Status, results[2].Status})
+	stored, err := us.Get(context.Background(), "same")
+	require.NoError(t, err)
+	assert.Equal(t, "https://yandex.com", stored.OriginalURL)
+}
+
+func TestShortURLBatchStatuses(t *testing.T) {
+	us := NewMockMapURLS(mockURLS{utils.HashURL("https://ya.ru"), "https://practicum.yandex.ru"})
+	require.NoError(t, us.Set(context.Background(), "existing", &storage.Store{OriginalURL: "https://yandex.com", UserID: 1}))
+	token, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
+
+	body := `[{"correlation_id": "new", "original_url": "https://ya.ru"},
+		{"correlation_id": "repeated", "original_url": "https://yandex.com"},
+		{"correlation_id": "bad url", "original_url": "not a url"},
+		{"correlation_id": "taken alias", "original_url": "https://go.dev", "alias": "existing"},
+		{"correlation_id": "bad ttl", "original_url": "https://go.dev", "ttl_seconds": -1}]`
+	r := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten/batch", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.AddCookie(&http.Cookie{Name: "userIDToken", Value: token})
+	w := httptest.NewRecorder()
+
+	newTestHandler(us).ShortURLBatch().ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusCreated, w.Code, "Wrong response code status")
+	var results []struct {
+		CorrelationID string `json:"correlation_id"`
+		ShortURL      string `json:"short_url"`
+		Status        string `json:"status"`
+		Error         string `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	require.Len(t, results, 5)
+
+	expected := []struct{ status, shortURL string }{
+		{BatchStatusCreated, ""},
+		{BatchStatusConflict, config.Options.BaseURL + "/existing"},
+		{BatchStatusInvalid, ""},
+		{BatchStatusConflict, ""},
+		{BatchStatusInvalid, ""},
+	}
+	for i, e := range expected {
+		assert.Equal(t, e.status, results[i].Status, results[i].CorrelationID)
+		if e.shortURL != "" {
+			assert.Equal(t, e.shortURL, results[i].ShortURL, results[i].CorrelationID)
+		}
+	}
+	assert.Equal(t, ErrAliasTaken.Error(), results[3].Error, "A taken alias is a conflict, as on /api/shorten")
+	assert.Empty(t, results[3].ShortURL)
+	assert.NotEqual(t, config.Options.BaseURL+"/"+utils.HashURL("https://ya.ru"), results[0].ShortURL, "Collided short code was reused")
+
+	stored, err := us.Get(context.Background(), strings.TrimPrefix(results[0].ShortURL, config.Options.BaseURL+"/"))
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", stored.OriginalURL)
+}
+
+func TestShortURLJSONExpiry(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{name: "ttl", body: `{"url": "https://yandex.com", "ttl_seconds": 60}`, expectedCode: http.StatusCreated},
+		{name: "expires_at", body: `{"url": "https://yandex.com", "expires_at": "` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`, expectedCode: http.StatusCreated},
+		{name: "expires_at in past", body: `{"url": "https://yandex.com", "expires_at": "2000-01-01T00:00:00Z"}`, expectedCode: http.StatusBadRequest},
+		{name: "negative ttl", body: `{"url": "https://yandex.com", "ttl_seconds": -1}`, expectedCode: http.StatusBadRequest},
+		{name: "both", body: `{"url": "https://yandex.com", "ttl_seconds": 60, "expires_at": "` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`, expectedCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			us := NewMockMapURLS()
+			r := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten", strings.NewReader(test.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			newTestHandler(us).ShortURLJSON().ServeHTTP(w, r)
+
+			assert.Equal(t, test.expectedCode, w.Code, "Wrong response code status")
+			if test.expectedCode != http.StatusCreated {
+				return
+			}
+			stored, err := us.Get(context.Background(), utils.HashURL("https://yandex.com"))
+			require.NoError(t, err)
+			assert.False(t, stored.ExpiresAt.IsZero(), "Expiry was not stored")
+		})
+	}
+}
+
+func TestGetShortURLExpired(t *testing.T) {
+	us := storage.NewURLStorage()
+	require.NoError(t, us.Set(context.Background(), "expired", &storage.Store{OriginalURL: "https://yandex.com", ExpiresAt: time.Now().Add(-time.Second)}))
+
+	r := httptest.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+	r.SetPathValue("id", "expired")
+	w := httptest.NewRecorder()
+
+	newTestHandler(storage.NewURLS(us)).GetShortURL().ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusGone, w.Code, "Wrong response code status")
+	assert.Empty(t, w.Header().Get("Location"))
+}
+
+func TestURLStats(t *testing.T) {
+	us := storage.NewURLStorage()
+	require.NoError(t, us.Set(context.Background(), "owned", &storage.Store{OriginalURL: "https://yandex.com", UserID: 1}))
+	require.NoError(t, us.Set(context.Background(), "foreign", &storage.Store{OriginalURL: "https://practicum.yandex.ru", UserID: 2}))
+	h := newTestHandler(storage.NewURLS(us))
+
+	for _, remoteAddr := range []string{"10.0.0.1:1234", "10.0.0.1:4321", "10.0.0.2:1234"} {
+		r := httptest.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+		r.RemoteAddr = remoteAddr
+		r.SetPathValue("id", "owned")
+		w := httptest.NewRecorder()
+		h.GetShortURL().ServeHTTP(w, r)
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.clicks.Run(ctx)
+
+	token, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		shortURL     string
+		expectedCode int
+	}{
+		{name: "owned link", shortURL: "owned", expectedCode: http.StatusOK},
+		{name: "foreign link", shortURL: "foreign", expectedCode: http.StatusForbidden},
+		{name: "unknown link", shortURL: "unknown", expectedCode: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/urls/"+test.shortURL+"/stats", nil)
+			r.AddCookie(&http.Cookie{Name: "userIDToken", Value: token})
+			r.SetPathValue("id", test.shortURL)
+			w := httptest.NewRecorder()
+
+			h.URLStats().ServeHTTP(w, r)
+
+			assert.Equal(t, test.expectedCode, w.Code, "Wrong response code status")
+			if test.expectedCode != http.StatusOK {
+				return
+			}
+			var stats storage.ClickStats
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+			assert.Equal(t, 3, stats.TotalClicks)
+			assert.Equal(t, 2, stats.UniqueVisitors)
+			require.Len(t, stats.Daily, 1)
+			assert.Equal(t, 3, stats.Daily[0].Clicks)
+		})
+	}
+}
+
+func TestAuthUniqueUserIDs(t *testing.T) {
+	counterPath := filepath.Join(t.TempDir(), "users.uid")
+	users, err := identity.NewCounterAllocator(counterPath, 0)
+	require.NoError(t, err)
+
+	h := newTestHandler(NewMockMapURLS())
+	h.users = users
+
+	const requests = 300
+	ids := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/urls", nil)
+			w := httptest.NewRecorder()
+			uid, _ := h.Auth(w, r)
+			ids <- uid
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]struct{})
+	maxID := 0
+	for uid := range ids {
+		assert.NotContains(t, seen, uid, "User ID issued twice")
+		seen[uid] = struct{}{}
+		if uid > maxID {
+			maxID = uid
+		}
+	}
+
+	restarted, err := identity.NewCounterAllocator(counterPath, 0)
+	require.NoError(t, err)
+	next, err := restarted.NextUserID(context.Background())
+	require.NoError(t, err)
+	assert.Greater(t, next, maxID, "User ID reused after restart")
+}
+
+func TestDeleteUserURLS(t *testing.T) {
+	us := storage.NewURLStorage()
+	require.NoError(t, us.Set(context.Background(), "mine1", &storage.Store{OriginalURL: "https://yandex.com", UserID: 1}))
+	require.NoError(t, us.Set(context.Background(), "mine2", &storage.Store{OriginalURL: "https://ya.ru", UserID: 1}))
+	require.NoError(t, us.Set(context.Background(), "theirs", &storage.Store{OriginalURL: "https://practicum.yandex.ru", UserID: 2}))
+	h := newTestHandler(storage.NewURLS(us))
+
+	token, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
+
+	for _, body := range []string{`["mine1", "theirs"]`, `["mine2"]`} {
+		r := httptest.NewRequest(http.MethodDelete, "http://localhost:8080/api/user/urls", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.AddCookie(&http.Cookie{Name: "userIDToken", Value: token})
+		w := httptest.NewRecorder()
+
+		h.DeleteUserURLS().ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusAccepted, w.Code, "Wrong response code status")
+	}
+
+	stored, _ := us.Get(context.Background(), "mine1")
+	assert.False(t, stored.DeletedFlag, "Deletion must not happen before the worker runs")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.deleter.Run(ctx)
+
+	for key, deleted := range map[string]bool{"mine1": true, "mine2": true, "theirs": false} {
+		stored, _ := us.Get(context.Background(), key)
+		assert.Equal(t, deleted, stored.DeletedFlag, key)
+	}
+}
+
+type unavailableStorage struct {
+	*storage.URLStorage
+}
+
+func (unavailableStorage) Get(context.Context, string) (storage.Store, error) {
+	return storage.Store{}, errors.New("connection refused")
+}
+
+func TestStorageErrorStatuses(t *testing.T) {
+	us := storage.NewURLStorage()
+	require.NoError(t, us.Set(context.Background(), "gone", &storage.Store{OriginalURL: "https://yandex.com", UserID: 1, DeletedFlag: true}))
+
+	tests := []struct {
+		name         string
+		storage      *storage.URLS
+		shortURL     string
+		expectedCode int
+	}{
+		{name: "not found", storage: storage.NewURLS(us), shortURL: "missing", expectedCode: http.StatusNotFound},
+		{name: "deleted", storage: storage.NewURLS(us), shortURL: "gone", expectedCode: http.StatusGone},
+		{name: "backend down", storage: storage.NewURLS(unavailableStorage{us}), shortURL: "gone", expectedCode: http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://localhost:8080", nil)
+			r.SetPathValue("id", test.shortURL)
+			w := httptest.NewRecorder()
+
+			newTestHandler(test.storage).GetShortURL().ServeHTTP(w, r)
+
+			assert.Equal(t, test.expectedCode, w.Code, "Wrong response code status")
+		})
+	}
+}
+
+func TestShortURLRepeated(t *testing.T) {
+	token, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
+
+	for _, generatorType := range []string{"hash", "random"} {
+		t.Run(generatorType, func(t *testing.T) {
+			h := newTestHandler(NewMockMapURLS())
+			h.generator, err = shortcode.NewGenerator(generatorType, 8, shortcode.Base62Alphabet, nil)
+			require.NoError(t, err)
+
+			var bodies []string
+			for _, expectedCode := range []int{http.StatusCreated, http.StatusConflict} {
+				r := httptest.NewRequest(http.MethodPost, "http://localhost:8080/", strings.NewReader("https://yandex.com"))
+				r.AddCookie(&http.Cookie{Name: "userIDToken", Value: token})
+				w := httptest.NewRecorder()
+
+				h.ShortURL().ServeHTTP(w, r)
+
+				assert.Equal(t, expectedCode, w.Code, "Wrong response code status")
+				bodies = append(bodies, w.Body.String())
+			}
+			assert.Equal(t, bodies[0], bodies[1], "Conflict must return the existing short url")
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/Yasuhiro-gh/url-shortener/internal/config"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRedirectType = errors.New("redirect_type must be one of 301, 302, 307, 308")
+var ErrInvalidForward = errors.New("forward_query and forward_path must be true or false")
+var ErrInvalidMaxClicks = errors.New("max_clicks must not be negative")
+
+// linkOptions are the optional link attributes accepted by every endpoint
+// creating links.
+type linkOptions struct {
+	RedirectType int  `json:"redirect_type,omitempty"`
+	ForwardQuery bool `json:"forward_query,omitempty"`
+	ForwardPath  bool `json:"forward_path,omitempty"`
+	// Password, if set, must be entered by visitors before the redirect.
+	Password string `json:"password,omitempty"`
+	// MaxClicks makes the link gone after that many redirects.
+	MaxClicks int64 `json:"max_clicks,omitempty"`
+}
+
+func (o linkOptions) apply(urlStore *storage.Store) error {
+	if o.RedirectType != 0 && !IsRedirectType(o.RedirectType) {
+		return ErrInvalidRedirectType
+	}
+	if o.MaxClicks < 0 {
+		return ErrInvalidMaxClicks
+	}
+	urlStore.MaxClicks = o.MaxClicks
+	urlStore.RedirectType = o.RedirectType
+	urlStore.ForwardQuery = o.ForwardQuery
+	urlStore.ForwardPath = o.ForwardPath
+	return setPassword(urlStore, o.Password)
+}
+
+func IsRedirectType(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// destination builds the redirect location for a visit of r. With
+// ForwardPath the path after the code is appended to the destination path,
+// with ForwardQuery the visitor's query parameters are added to the
+// destination query. Parameters the destination already has win: the
+// visitor's values for them are dropped, so links cannot be retargeted by
+// overriding their fixed parameters. ok is false if r has a path suffix the
+// link does not forward.
+func destination(urlStore storage.Store, r *http.Request) (location string, ok bool) {
+	suffix := r.PathValue("*")
+	if suffix != "" && !urlStore.ForwardPath {
+		return "", false
+	}
+	if suffix == "" && (!urlStore.ForwardQuery || r.URL.RawQuery == "") {
+		return urlStore.OriginalURL, true
+	}
+
+	dest, err := url.Parse(urlStore.OriginalURL)
+	if err != nil {
+		return urlStore.OriginalURL, suffix == ""
+	}
+	if suffix != "" {
+		// Cleaning a rooted suffix drops leading dot segments, so the
+		// visitor cannot climb above the destination path.
+		dest.Path = strings.TrimSuffix(dest.Path, "/") + path.Clean("/"+suffix)
+		if strings.HasSuffix(suffix, "/") && !strings.HasSuffix(dest.Path, "/") {
+			dest.Path += "/"
+		}
+		dest.RawPath = ""
+	}
+	if urlStore.ForwardQuery && r.URL.RawQuery != "" {
+		fixed := dest.Query()
+		extra := make(url.Values)
+		for key, values := range r.URL.Query() {
+			if !fixed.Has(key) {
+				extra[key] = values
+			}
+		}
+		if len(extra) > 0 {
+			if dest.RawQuery != "" {
+				dest.RawQuery += "&"
+			}
+			dest.RawQuery += extra.Encode()
+		}
+	}
+	return dest.String(), true
+}
+
+// redirect answers with the link's redirect status. Permanent redirects may
+// be cached by clients, but not past the link's expiry, and never by shared
+// caches: the response may set the visitor's auth cookie. Temporary ones and
+// the ones of click limited links are revalidated so every visit is counted.
+func redirect(w http.ResponseWriter, urlStore storage.Store, location string, now time.Time) {
+	status := urlStore.RedirectType
+	if status == 0 {
+		status = config.Options.RedirectType
+	}
+
+	if (status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect) && urlStore.MaxClicks == 0 {
+		maxAge := config.Options.RedirectMaxAge
+		if !urlStore.ExpiresAt.IsZero() {
+			maxAge = min(maxAge, urlStore.ExpiresAt.Sub(now))
+		}
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Location", location)
+	w.WriteHeader(status)
+}
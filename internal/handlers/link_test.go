@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedirectTypes(t *testing.T) {
+	us := storage.NewURLStorage()
+	ctx := context.Background()
+	require.NoError(t, us.Set(ctx, "default", &storage.Store{OriginalURL: "https://yandex.com", UserID: 1}))
+	require.NoError(t, us.Set(ctx, "moved", &storage.Store{OriginalURL: "https://ya.ru", UserID: 1, RedirectType: http.StatusMovedPermanently}))
+	require.NoError(t, us.Set(ctx, "expiring", &storage.Store{OriginalURL: "https://practicum.yandex.ru", UserID: 1,
+		RedirectType: http.StatusPermanentRedirect, ExpiresAt: time.Now().Add(time.Minute)}))
+	h := newTestHandler(storage.NewURLS(us))
+
+	tests := []struct {
+		shortURL     string
+		expectedCode int
+		cacheControl string
+	}{
+		{shortURL: "default", expectedCode: http.StatusTemporaryRedirect, cacheControl: "private, no-cache"},
+		{shortURL: "moved", expectedCode: http.StatusMovedPermanently, cacheControl: "private, max-age=86400"},
+		{shortURL: "expiring", expectedCode: http.StatusPermanentRedirect, cacheControl: "private, max-age=59"},
+	}
+	for _, test := range tests {
+		t.Run(test.shortURL, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://localhost:8080/"+test.shortURL, nil)
+			r.SetPathValue("id", test.shortURL)
+			w := httptest.NewRecorder()
+
+			h.GetShortURL().ServeHTTP(w, r)
+
+			assert.Equal(t, test.expectedCode, w.Code, "Wrong response code status")
+			assert.Equal(t, test.cacheControl, w.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestShortURLJSONRedirectType(t *testing.T) {
+	us := storage.NewURLStorage()
+	h := newTestHandler(storage.NewURLS(us))
+
+	for body, expectedCode := range map[string]int{
+		`{"url": "https://yandex.com", "alias": "seo", "redirect_type": 308}`: http.StatusCreated,
+		`{"url": "https://ya.ru", "redirect_type": 303}`:                      http.StatusBadRequest,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		h.ShortURLJSON().ServeHTTP(w, r)
+
+		assert.Equal(t, expectedCode, w.Code, body)
+	}
+
+	stored, err := us.Get(context.Background(), "seo")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusPermanentRedirect, stored.RedirectType)
+}
+
+func TestRedirectForwarding(t *testing.T) {
+	us := storage.NewURLStorage()
+	ctx := context.Background()
+	require.NoError(t, us.Set(ctx, "plain", &storage.Store{OriginalURL: "https://yandex.com/?ref=a", UserID: 1}))
+	require.NoError(t, us.Set(ctx, "query", &storage.Store{OriginalURL: "https://yandex.com/search?ref=a#top", UserID: 1, ForwardQuery: true}))
+	require.NoError(t, us.Set(ctx, "docs", &storage.Store{OriginalURL: "https://ya.ru/docs/", UserID: 1, ForwardPath: true}))
+	h := newTestHandler(storage.NewURLS(us))
+
+	tests := []struct {
+		name         string
+		shortURL     string
+		suffix       string
+		query        string
+		expectedCode int
+		location     string
+	}{
+		{name: "query not forwarded", shortURL: "plain", query: "utm_source=x", expectedCode: http.StatusTemporaryRedirect, location: "https://yandex.com/?ref=a"},
+		{name: "path not forwarded", shortURL: "plain", suffix: "page", expectedCode: http.StatusNotFound},
+		{name: "query merged", shortURL: "query", query: "utm_source=x&ref=b&ref=c", expectedCode: http.StatusTemporaryRedirect,
+			location: "https://yandex.com/search?ref=a&utm_source=x#top"},
+		{name: "path joined", shortURL: "docs", suffix: "guide/page", query: "q=1", expectedCode: http.StatusTemporaryRedirect,
+			location: "https://ya.ru/docs/guide/page"},
+		{name: "dot segments", shortURL: "docs", suffix: "../../etc/", expectedCode: http.StatusTemporaryRedirect, location: "https://ya.ru/docs/etc/"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://localhost:8080/"+test.shortURL+"?"+test.query, nil)
+			r.SetPathValue("id", test.shortURL)
+			r.SetPathValue("*", test.suffix)
+			w := httptest.NewRecorder()
+
+			h.GetShortURL().ServeHTTP(w, r)
+
+			assert.Equal(t, test.expectedCode, w.Code, "Wrong response code status")
+			assert.Equal(t, test.location, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestOneTimeURL(t *testing.T) {
+	us := storage.NewURLStorage()
+	require.NoError(t, us.Set(context.Background(), "once", &storage.Store{OriginalURL: "https://yandex.com", UserID: 1,
+		RedirectType: http.StatusPermanentRedirect, MaxClicks: 1}))
+	h := newTestHandler(storage.NewURLS(us))
+
+	for _, expectedCode := range []int{http.StatusPermanentRedirect, http.StatusGone} {
+		r := httptest.NewRequest(http.MethodGet, "http://localhost:8080/once", nil)
+		r.SetPathValue("id", "once")
+		w := httptest.NewRecorder()
+
+		h.GetShortURL().ServeHTTP(w, r)
+
+		assert.Equal(t, expectedCode, w.Code, "Wrong response code status")
+		if expectedCode == http.StatusPermanentRedirect {
+			assert.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"), "Limited links must not be cached")
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Yasuhiro-gh/url-shortener/internal/config"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+var ErrInvalidListLimit = errors.New("limit must be between 1 and 1000")
+var ErrInvalidListSort = errors.New("sort must be one of created, clicks, original_url, or deleted in the trash")
+
+type userURL struct {
+	ShortURL     string     `json:"short_url"`
+	OriginalURL  string     `json:"original_url"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	RedirectType int        `json:"redirect_type,omitempty"`
+	ForwardQuery bool       `json:"forward_query,omitempty"`
+	ForwardPath  bool       `json:"forward_path,omitempty"`
+	Protected    bool       `json:"protected,omitempty"`
+	MaxClicks    int64      `json:"max_clicks,omitempty"`
+	UsedClicks   int64      `json:"used_clicks,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	Clicks       int64      `json:"clicks"`
+	IsDeleted    bool       `json:"is_deleted,omitempty"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+}
+
+func newUserURL(code string, u storage.Store) userURL {
+	resp := userURL{
+		ShortURL:     config.Options.BaseURL + "/" + code,
+		OriginalURL:  u.OriginalURL,
+		RedirectType: u.RedirectType,
+		ForwardQuery: u.ForwardQuery,
+		ForwardPath:  u.ForwardPath,
+		Protected:    u.PasswordHash != "",
+		MaxClicks:    u.MaxClicks,
+		UsedClicks:   u.UsedClicks,
+		CreatedAt:    u.CreatedAt,
+		Clicks:       u.Clicks,
+		IsDeleted:    u.DeletedFlag,
+	}
+	if !u.ExpiresAt.IsZero() {
+		resp.ExpiresAt = &u.ExpiresAt
+	}
+	if u.DeletedFlag && !u.DeletedAt.IsZero() {
+		resp.DeletedAt = &u.DeletedAt
+	}
+	return resp
+}
+
+// parseListOptions reads the listing parameters; the trash lists deleted URLs
+// only and sorts them by deletion time unless asked otherwise. Without limit
+// and cursor the whole listing is returned, as before paging existed.
+func parseListOptions(query url.Values, trash bool) (storage.ListOptions, error) {
+	opts := storage.ListOptions{
+		Cursor: query.Get("cursor"),
+		Sort:   query.Get("sort"),
+		Query:  query.Get("q"),
+		Trash:  trash,
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxListLimit {
+			return opts, ErrInvalidListLimit
+		}
+		opts.Limit = n
+	} else if opts.Cursor != "" {
+		opts.Limit = defaultListLimit
+	}
+	switch opts.Sort {
+	case "":
+		opts.Sort = storage.SortCreated
+		if trash {
+			opts.Sort = storage.SortDeleted
+		}
+	case storage.SortCreated, storage.SortClicks, storage.SortOriginalURL:
+	case storage.SortDeleted:
+		if !trash {
+			return opts, ErrInvalidListSort
+		}
+	default:
+		return opts, ErrInvalidListSort
+	}
+	if includeDeleted := query.Get("include_deleted"); includeDeleted != "" && !trash {
+		b, err := strconv.ParseBool(includeDeleted)
+		if err != nil {
+			return opts, err
+		}
+		opts.IncludeDeleted = b
+	}
+	return opts, nil
+}
+
+// nextPageLink keeps the request's filters and only swaps the cursor.
+func nextPageLink(r *http.Request, cursor string) string {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	return "<" + config.Options.BaseURL + r.URL.Path + "?" + query.Encode() + `>; rel="next"`
+}
+
+func (h *URLHandler) UserURLS() http.HandlerFunc {
+	return h.listUserURLS(false)
+}
+
+func (h *URLHandler) UserURLSTrash() http.HandlerFunc {
+	return h.listUserURLS(true)
+}
+
+func (h *URLHandler) listUserURLS(trash bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := authenticated(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		opts, err := parseListOptions(r.URL.Query(), trash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := h.GetUserURLS(r.Context(), userID, opts)
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), storageStatus(err))
+			return
+		}
+		if len(page.URLs) == 0 && opts.Cursor == "" {
+			http.Error(w, "There is no your urls", http.StatusNoContent)
+			return
+		}
+
+		userURLS := make([]userURL, 0, len(page.URLs))
+		for _, u := range page.URLs {
+			userURLS = append(userURLS, newUserURL(u.ShortURL, u))
+		}
+
+		resp, err := json.Marshal(userURLS)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if page.NextCursor != "" {
+			w.Header().Set("Link", nextPageLink(r, page.NextCursor))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(resp)
+	}
+}
+
+func (h *URLHandler) RestoreUserURLS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+			http.Error(w, "Only JSON content type is supported.", http.StatusBadRequest)
+			return
+		}
+
+		userID, err := authenticated(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var shortURLS []string
+		if err := json.NewDecoder(r.Body).Decode(&shortURLS); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		restored, err := h.Undelete(r.Context(), userID, shortURLS)
+		if err != nil {
+			http.Error(w, err.Error(), storageStatus(err))
+			return
+		}
+
+		resp := make([]string, len(restored))
+		for i, code := range restored {
+			resp[i] = config.Options.BaseURL + "/" + code
+		}
+		body, err := json.Marshal(resp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(body)
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Yasuhiro-gh/url-shortener/internal/auth"
+	"github.com/Yasuhiro-gh/url-shortener/internal/config"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var nextLink = regexp.MustCompile(`^<(.+)>; rel="next"$`)
+
+func TestUserURLSPages(t *testing.T) {
+	us := storage.NewURLStorage()
+	for i := 0; i < 5; i++ {
+		key := "key" + strconv.Itoa(i)
+		require.NoError(t, us.Set(context.Background(), key, &storage.Store{OriginalURL: "https://yandex.com/" + key, UserID: 1}))
+	}
+	require.NoError(t, us.Set(context.Background(), "other", &storage.Store{OriginalURL: "https://ya.ru", UserID: 1}))
+	require.NoError(t, us.Delete(context.Background(), "key0", 1))
+	h := newTestHandler(storage.NewURLS(us))
+
+	token, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
+
+	var got []string
+	target := config.Options.BaseURL + "/api/user/urls?limit=3&q=yandex&sort=original_url"
+	for target != "" {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.AddCookie(&http.Cookie{Name: "userIDToken", Value: token})
+		w := httptest.NewRecorder()
+
+		h.UserURLS().ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code, "Wrong response code status")
+		var page []userURL
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		for _, u := range page {
+			got = append(got, u.OriginalURL)
+		}
+
+		target = ""
+		if link := w.Header().Get("Link"); link != "" {
+			m := nextLink.FindStringSubmatch(link)
+			require.NotNil(t, m, "Malformed Link header %q", link)
+			next, err := url.Parse(m[1])
+			require.NoError(t, err)
+			assert.Equal(t, "yandex", next.Query().Get("q"), "The next page must keep the filters")
+			target = m[1]
+		}
+	}
+	assert.Equal(t, []string{"https://yandex.com/key1", "https://yandex.com/key2", "https://yandex.com/key3", "https://yandex.com/key4"}, got)
+}
+
+func TestUserURLSWithoutLimit(t *testing.T) {
+	us := storage.NewURLStorage()
+	for i := 0; i < defaultListLimit+5; i++ {
+		key := "key" + strconv.Itoa(i)
+		require.NoError(t, us.Set(context.Background(), key, &storage.Store{OriginalURL: "https://yandex.com/" + key, UserID: 1}))
+	}
+	h := newTestHandler(storage.NewURLS(us))
+	token, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, config.Options.BaseURL+"/api/user/urls", nil)
+	r.AddCookie(&http.Cookie{Name: "userIDToken", Value: token})
+	w := httptest.NewRecorder()
+
+	h.UserURLS().ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code, "Wrong response code status")
+	var page []userURL
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Len(t, page, defaultListLimit+5, "Clients that do not page must get every url")
+	assert.Empty(t, w.Header().Get("Link"))
+}
+
+func TestUserURLSBadRequest(t *testing.T) {
+	h := newTestHandler(NewMockMapURLS())
+	token, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
+
+	for _, query := range []string{"limit=0", "limit=1001", "limit=ten", "sort=newest", "include_deleted=maybe", "cursor=garbage"} {
+		r := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/urls?"+query, nil)
+		r.AddCookie(&http.Cookie{Name: "userIDToken", Value: token})
+		w := httptest.NewRecorder()
+
+		h.UserURLS().ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestUserURLSTrashAndRestore(t *testing.T) {
+	us := storage.NewURLStorage()
+	require.NoError(t, us.Set(context.Background(), "kept", &storage.Store{OriginalURL: "https://yandex.com", UserID: 1}))
+	require.NoError(t, us.Set(context.Background(), "binned", &storage.Store{OriginalURL: "https://ya.ru", UserID: 1}))
+	require.NoError(t, us.Delete(context.Background(), "binned", 1))
+	h := newTestHandler(storage.NewURLS(us))
+
+	token, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/urls/trash", nil)
+	r.AddCookie(&http.Cookie{Name: "userIDToken", Value: token})
+	w := httptest.NewRecorder()
+	h.UserURLSTrash().ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code, "Wrong response code status")
+	var trash []userURL
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &trash))
+	require.Len(t, trash, 1)
+	assert.Equal(t, config.Options.BaseURL+"/binned", trash[0].ShortURL)
+	assert.NotNil(t, trash[0].DeletedAt)
+
+	r = httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/urls/restore", strings.NewReader(`["binned", "kept"]`))
+	r.Header.Set("Content-Type", "application/json")
+	r.AddCookie(&http.Cookie{Name: "userIDToken", Value: token})
+	w = httptest.NewRecorder()
+	h.RestoreUserURLS().ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code, "Wrong response code status")
+	assert.JSONEq(t, `["`+config.Options.BaseURL+`/binned"]`, w.Body.String())
+	_, err = us.Get(context.Background(), "binned")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/analytics"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"golang.org/x/crypto/bcrypt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidPassword = errors.New("password must be at most 72 bytes")
+
+var passwordPage = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Password required</title></head>
+<body>
+<form method="post">
+<p>This link is protected by a password.</p>
+{{if .}}<p>{{.}}</p>
+{{end}}<input type="password" name="password" autofocus required>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+// setPassword stores the hash of password, an empty password removes it.
+func setPassword(urlStore *storage.Store, password string) error {
+	if password == "" {
+		urlStore.PasswordHash = ""
+		return nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return ErrInvalidPassword
+	}
+	if err != nil {
+		return err
+	}
+	urlStore.PasswordHash = string(hash)
+	return nil
+}
+
+// passwordForm asks for the password of a protected link; the form posts
+// back to the link itself.
+func passwordForm(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = passwordPage.Execute(w, message)
+}
+
+// UnlockShortURL checks the password posted by the form of a protected link
+// and redirects on a match. Guesses are limited per client and link, with the
+// client told by X-Forwarded-For only behind a trusted proxy. The
+// redirect is always 303 See Other so the password is not posted on to the
+// destination, and nothing is cached: every visit asks again.
+func (h *URLHandler) UnlockShortURL() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		shortURL, urlStore, location, ok := h.visit(w, r, now)
+		if !ok {
+			return
+		}
+
+		if urlStore.PasswordHash != "" {
+			key := analytics.PeerIP(r) + " " + shortURL
+			if allowed, retryAfter := h.guesses.Allow(key, now); !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+				passwordForm(w, http.StatusTooManyRequests, "Too many attempts, try again later.")
+				return
+			}
+			err := bcrypt.CompareHashAndPassword([]byte(urlStore.PasswordHash), []byte(r.PostFormValue("password")))
+			if err != nil {
+				passwordForm(w, http.StatusForbidden, "Wrong password.")
+				return
+			}
+			h.guesses.Reset(key)
+		}
+		if !h.useClick(w, r, shortURL, urlStore) {
+			return
+		}
+
+		h.clicks.Track(analytics.NewClick(r, shortURL))
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusSeeOther)
+	}
+}
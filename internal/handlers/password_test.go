@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/analytics"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPasswordProtectedURL(t *testing.T) {
+	us := storage.NewURLStorage()
+	urlStore := storage.Store{OriginalURL: "https://yandex.com", UserID: 1}
+	require.NoError(t, setPassword(&urlStore, "secret"))
+	require.NoError(t, us.Set(context.Background(), "locked", &urlStore))
+	h := newTestHandler(storage.NewURLS(us))
+
+	r := httptest.NewRequest(http.MethodGet, "http://localhost:8080/locked", nil)
+	r.SetPathValue("id", "locked")
+	w := httptest.NewRecorder()
+	h.GetShortURL().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), `name="password"`)
+	assert.Empty(t, w.Header().Get("Location"))
+
+	guesses := 0
+	unlock := func(password string, remoteAddr string) *httptest.ResponseRecorder {
+		body := url.Values{"password": {password}}.Encode()
+		r := httptest.NewRequest(http.MethodPost, "http://localhost:8080/locked", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		guesses++
+		r.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(guesses))
+		r.RemoteAddr = remoteAddr
+		r.SetPathValue("id", "locked")
+		w := httptest.NewRecorder()
+		h.UnlockShortURL().ServeHTTP(w, r)
+		return w
+	}
+
+	w = unlock("secret", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "https://yandex.com", w.Header().Get("Location"))
+
+	for range 5 {
+		assert.Equal(t, http.StatusForbidden, unlock("guess", "192.0.2.2:1234").Code)
+	}
+	w = unlock("secret", "192.0.2.2:1234")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "X-Forwarded-For of an untrusted peer must not reset the limit")
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusSeeOther, unlock("secret", "192.0.2.3:1234").Code)
+}
+
+func TestPeerIP(t *testing.T) {
+	proxies, err := analytics.ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	require.NoError(t, err)
+	analytics.SetTrustedProxies(proxies)
+	defer analytics.SetTrustedProxies(nil)
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{remoteAddr: "203.0.113.5:1234", forwarded: "198.51.100.1", expected: "203.0.113.5"},
+		{remoteAddr: "192.0.2.1:1234", forwarded: "198.51.100.1", expected: "198.51.100.1"},
+		{remoteAddr: "10.1.2.3:1234", forwarded: "198.51.100.9, 198.51.100.1, 10.0.0.1", expected: "198.51.100.1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "http://localhost:8080/locked", nil)
+		r.RemoteAddr = test.remoteAddr
+		r.Header.Set("X-Forwarded-For", test.forwarded)
+		assert.Equal(t, test.expected, analytics.PeerIP(r), test.remoteAddr)
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"github.com/Yasuhiro-gh/url-shortener/internal/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// updateRequest holds the attributes to change; absent fields are kept.
+// ttl_seconds of 0 without expires_at removes the expiry, redirect_type of 0
+// restores the default redirect, max_clicks of 0 removes the click limit and
+// an empty password removes the password.
+type updateRequest struct {
+	OriginalURL  *string    `json:"original_url"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	TTLSeconds   *int64     `json:"ttl_seconds"`
+	RedirectType *int       `json:"redirect_type"`
+	ForwardQuery *bool      `json:"forward_query"`
+	ForwardPath  *bool      `json:"forward_path"`
+	Password     *string    `json:"password"`
+	MaxClicks    *int64     `json:"max_clicks"`
+}
+
+func (req updateRequest) apply(urlStore *storage.Store) error {
+	if req.OriginalURL != nil {
+		switch {
+		case *req.OriginalURL == "":
+			return ErrEmptyURL
+		case !utils.IsValidURL(*req.OriginalURL):
+			return ErrInvalidURL
+		}
+		urlStore.OriginalURL = *req.OriginalURL
+	}
+	if req.ExpiresAt != nil || req.TTLSeconds != nil {
+		var ttlSeconds int64
+		if req.TTLSeconds != nil {
+			ttlSeconds = *req.TTLSeconds
+		}
+		expiresAt, err := parseExpiry(req.ExpiresAt, ttlSeconds)
+		if err != nil {
+			return err
+		}
+		urlStore.ExpiresAt = expiresAt
+	}
+	if req.RedirectType != nil {
+		if *req.RedirectType != 0 && !IsRedirectType(*req.RedirectType) {
+			return ErrInvalidRedirectType
+		}
+		urlStore.RedirectType = *req.RedirectType
+	}
+	if req.ForwardQuery != nil {
+		urlStore.ForwardQuery = *req.ForwardQuery
+	}
+	if req.ForwardPath != nil {
+		urlStore.ForwardPath = *req.ForwardPath
+	}
+	if req.MaxClicks != nil {
+		if *req.MaxClicks < 0 {
+			return ErrInvalidMaxClicks
+		}
+		urlStore.MaxClicks = *req.MaxClicks
+	}
+	if req.Password != nil {
+		return setPassword(urlStore, *req.Password)
+	}
+	return nil
+}
+
+// ownedURL loads a URL of the authenticated user, deleted ones included.
+func (h *URLHandler) ownedURL(w http.ResponseWriter, r *http.Request) (string, storage.Store, bool) {
+	userID, err := authenticated(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return "", storage.Store{}, false
+	}
+
+	shortURL := r.PathValue("id")
+	urlStore, err := h.Get(r.Context(), shortURL)
+	if errors.Is(err, storage.ErrDeleted) {
+		err = nil
+	}
+	if err == nil && urlStore.UserID != userID {
+		err = storage.ErrForbidden
+	}
+	if err != nil {
+		http.Error(w, err.Error(), storageStatus(err))
+		return "", storage.Store{}, false
+	}
+	return shortURL, urlStore, true
+}
+
+func (h *URLHandler) UpdateUserURL() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+			http.Error(w, "Only JSON content type is supported.", http.StatusBadRequest)
+			return
+		}
+
+		shortURL, urlStore, ok := h.ownedURL(w, r)
+		if !ok {
+			return
+		}
+		if urlStore.DeletedFlag {
+			http.Error(w, storage.ErrDeleted.Error(), http.StatusGone)
+			return
+		}
+
+		var req updateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := req.apply(&urlStore); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		httpStatus := http.StatusOK
+		err := h.Update(r.Context(), shortURL, &urlStore)
+		var conflict *storage.ConflictError
+		switch {
+		case errors.As(err, &conflict):
+			shortURL, urlStore = conflict.Existing.ShortURL, conflict.Existing
+			httpStatus = http.StatusConflict
+		case err != nil:
+			http.Error(w, err.Error(), storageStatus(err))
+			return
+		}
+
+		resp, err := json.Marshal(newUserURL(shortURL, urlStore))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(httpStatus)
+		_, _ = w.Write(resp)
+	}
+}
+
+func (h *URLHandler) UserURLHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		shortURL, _, ok := h.ownedURL(w, r)
+		if !ok {
+			return
+		}
+
+		history, err := h.GetURLHistory(r.Context(), shortURL)
+		if err != nil {
+			http.Error(w, err.Error(), storageStatus(err))
+			return
+		}
+
+		resp, err := json.Marshal(history)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(resp)
+	}
+}
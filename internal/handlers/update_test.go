@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Yasuhiro-gh/url-shortener/internal/auth"
+	"github.com/Yasuhiro-gh/url-shortener/internal/config"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpdateUserURL(t *testing.T) {
+	us := storage.NewURLStorage()
+	require.NoError(t, us.Set(context.Background(), "mine", &storage.Store{OriginalURL: "https://yandex.com", UserID: 1}))
+	require.NoError(t, us.Set(context.Background(), "other", &storage.Store{OriginalURL: "https://ya.ru", UserID: 1}))
+	require.NoError(t, us.Set(context.Background(), "theirs", &storage.Store{OriginalURL: "https://practicum.yandex.ru", UserID: 2}))
+	h := newTestHandler(storage.NewURLS(us))
+
+	token, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		shortURL     string
+		body         string
+		expectedCode int
+		expectedURL  string
+	}{
+		{name: "not owner", shortURL: "theirs", body: `{"original_url": "https://yandex.ru"}`, expectedCode: http.StatusForbidden},
+		{name: "unknown", shortURL: "nothing", body: `{"original_url": "https://yandex.ru"}`, expectedCode: http.StatusNotFound},
+		{name: "invalid url", shortURL: "mine", body: `{"original_url": "yandex"}`, expectedCode: http.StatusBadRequest},
+		{name: "invalid ttl", shortURL: "mine", body: `{"ttl_seconds": -1}`, expectedCode: http.StatusBadRequest},
+		{name: "already shortened", shortURL: "mine", body: `{"original_url": "https://ya.ru"}`, expectedCode: http.StatusConflict, expectedURL: "https://ya.ru"},
+		{name: "updated", shortURL: "mine", body: `{"original_url": "https://yandex.ru", "ttl_seconds": 60}`, expectedCode: http.StatusOK, expectedURL: "https://yandex.ru"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "http://localhost:8080/api/user/urls/"+test.shortURL, strings.NewReader(test.body))
+			r.SetPathValue("id", test.shortURL)
+			r.Header.Set("Content-Type", "application/json")
+			r.AddCookie(&http.Cookie{Name: "userIDToken", Value: token})
+			w := httptest.NewRecorder()
+
+			h.UpdateUserURL().ServeHTTP(w, r)
+
+			require.Equal(t, test.expectedCode, w.Code, "Wrong response code status")
+			if test.expectedURL != "" {
+				var resp userURL
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+				assert.Equal(t, test.expectedURL, resp.OriginalURL)
+			}
+		})
+	}
+
+	stored, err := us.Get(context.Background(), "mine")
+	require.NoError(t, err)
+	assert.Equal(t, "https://yandex.ru", stored.OriginalURL)
+	assert.False(t, stored.ExpiresAt.IsZero())
+
+	r := httptest.NewRequest(http.MethodGet, config.Options.BaseURL+"/api/user/urls/mine/history", nil)
+	r.SetPathValue("id", "mine")
+	r.AddCookie(&http.Cookie{Name: "userIDToken", Value: token})
+	w := httptest.NewRecorder()
+
+	h.UserURLHistory().ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code, "Wrong response code status")
+	var history []storage.Change
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	require.Len(t, history, 1)
+	assert.Equal(t, "https://yandex.com", history[0].OriginalURL)
+}
@@ -18,7 +18,7 @@ type (
 	}
 )
 
-var sugar zap.SugaredLogger
+var sugar = *zap.NewNop().Sugar()
 
 func Run() {
 	logger, err := zap.NewProduction()
@@ -30,6 +30,14 @@ func Run() {
 	sugar = *logger.Sugar()
 }
 
+func Infoln(args ...interface{}) {
+	sugar.Infoln(args...)
+}
+
+func Errorln(args ...interface{}) {
+	sugar.Errorln(args...)
+}
+
 func (r *loggingResponseWriter) Write(b []byte) (int, error) {
 	size, err := r.ResponseWriter.Write(b)
 	r.responseData.size += size
@@ -41,6 +49,10 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode
 }
 
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 func Logging(h http.HandlerFunc) http.Handler {
 	logFn := func(w http.ResponseWriter, r *http.Request) {
 		startTime := time.Now()
@@ -0,0 +1,213 @@
+package analytics
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"github.com/Yasuhiro-gh/url-shortener/internal/logger"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Pipeline struct {
+	storage       storage.ClickStorages
+	events        chan storage.Click
+	batchSize     int
+	flushInterval time.Duration
+	dropped       atomic.Int64
+}
+
+func NewPipeline(cs storage.ClickStorages, bufferSize int, batchSize int, flushInterval time.Duration) *Pipeline {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if flushInterval <= 0 {
+		flushInterval = time.Second
+	}
+	return &Pipeline{
+		storage:       cs,
+		events:        make(chan storage.Click, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+	}
+}
+
+func NewClick(r *http.Request, shortURL string) storage.Click {
+	return storage.Click{
+		ShortURL:  shortURL,
+		ClickedAt: time.Now().UTC(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IPHash:    HashIP(PeerIP(r)),
+	}
+}
+
+var trustedProxies atomic.Pointer[[]netip.Prefix]
+
+// ParseTrustedProxies parses a comma separated list of IPs and CIDR ranges.
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func SetTrustedProxies(prefixes []netip.Prefix) {
+	trustedProxies.Store(&prefixes)
+}
+
+func isTrustedProxy(ip string) bool {
+	prefixes := trustedProxies.Load()
+	if prefixes == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range *prefixes {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerIP is the address of the client that cannot be spoofed: the connection
+// peer, or, when the peer is a trusted proxy, the last X-Forwarded-For hop
+// that is not one. Use it where the address guards something.
+func PeerIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrustedProxy(hop) {
+			return hop
+		}
+		ip = hop
+	}
+	return ip
+}
+
+var ipSalt atomic.Pointer[[]byte]
+
+// SetIPSalt sets the secret HashIP keys its hashes with, so the hashes of the
+// small IPv4 space cannot be reversed by brute force.
+func SetIPSalt(salt []byte) {
+	ipSalt.Store(&salt)
+}
+
+// GenerateIPSalt returns a random salt for servers without a configured one.
+func GenerateIPSalt() ([]byte, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+func HashIP(ip string) string {
+	var salt []byte
+	if s := ipSalt.Load(); s != nil {
+		salt = *s
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(ip))
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+// Track never blocks the redirect: when the buffer is full the event is dropped.
+func (p *Pipeline) Track(click storage.Click) {
+	select {
+	case p.events <- click:
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+func (p *Pipeline) Dropped() int64 {
+	return p.dropped.Load()
+}
+
+func (p *Pipeline) Stats(ctx context.Context, shortURL string) (storage.ClickStats, error) {
+	return p.storage.GetClickStats(ctx, shortURL)
+}
+
+func (p *Pipeline) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]storage.Click, 0, p.batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			p.drain(batch)
+			return
+		case click := <-p.events:
+			batch = append(batch, click)
+			if len(batch) >= p.batchSize {
+				batch = p.flush(context.Background(), batch)
+			}
+		case <-ticker.C:
+			batch = p.flush(context.Background(), batch)
+		}
+	}
+}
+
+func (p *Pipeline) drain(batch []storage.Click) {
+	for {
+		select {
+		case click := <-p.events:
+			batch = append(batch, click)
+			if len(batch) >= p.batchSize {
+				batch = p.flush(context.Background(), batch)
+			}
+		default:
+			p.flush(context.Background(), batch)
+			return
+		}
+	}
+}
+
+func (p *Pipeline) flush(ctx context.Context, batch []storage.Click) []storage.Click {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := p.storage.SaveClicks(ctx, batch); err != nil {
+		logger.Errorln("analytics", "error", err, "lost", len(batch))
+	}
+	return batch[:0]
+}
@@ -0,0 +1,149 @@
+package analytics
+
+import (
+	"context"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewClickHashesPeer(t *testing.T) {
+	defer SetTrustedProxies(nil)
+	SetIPSalt([]byte("salt"))
+
+	click := func(forwardedFor string) string {
+		r := httptest.NewRequest(http.MethodGet, "http://localhost:8080/abc", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+		return NewClick(r, "abc").IPHash
+	}
+
+	SetTrustedProxies(nil)
+	assert.Equal(t, click("1.1.1.1"), click("2.2.2.2"), "A client must not pick its own visitor hash")
+	assert.Equal(t, HashIP("10.0.0.1"), click("1.1.1.1"))
+
+	proxies, err := ParseTrustedProxies("10.0.0.0/8")
+	require.NoError(t, err)
+	SetTrustedProxies(proxies)
+	assert.NotEqual(t, click("1.1.1.1"), click("2.2.2.2"), "Clients behind a trusted proxy must be told apart")
+	assert.Equal(t, HashIP("1.1.1.1"), click("1.1.1.1"))
+}
+
+func TestHashIPSalted(t *testing.T) {
+	SetIPSalt([]byte("one"))
+	first := HashIP("1.1.1.1")
+	SetIPSalt([]byte("two"))
+	assert.NotEqual(t, first, HashIP("1.1.1.1"), "The hash must depend on the salt")
+	assert.Equal(t, HashIP("1.1.1.1"), HashIP("1.1.1.1"))
+}
+
+type recordingClicks struct {
+	mu      sync.Mutex
+	batches [][]string
+	saves   chan struct{}
+}
+
+func newRecordingClicks() *recordingClicks {
+	return &recordingClicks{saves: make(chan struct{}, 100)}
+}
+
+func (rc *recordingClicks) SaveClicks(ctx context.Context, clicks []storage.Click) error {
+	batch := make([]string, 0, len(clicks))
+	for _, c := range clicks {
+		batch = append(batch, c.ShortURL)
+	}
+	rc.mu.Lock()
+	rc.batches = append(rc.batches, batch)
+	rc.mu.Unlock()
+	rc.saves <- struct{}{}
+	return nil
+}
+
+func (rc *recordingClicks) GetClickStats(ctx context.Context, shortURL string) (storage.ClickStats, error) {
+	return storage.ClickStats{}, nil
+}
+
+func (rc *recordingClicks) recorded() [][]string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([][]string(nil), rc.batches...)
+}
+
+func waitSaves(t *testing.T, rc *recordingClicks, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-rc.saves:
+		case <-time.After(time.Second):
+			t.Fatalf("Got %d of %d click batches", i, n)
+		}
+	}
+}
+
+func TestPipelineFlushesFullBatch(t *testing.T) {
+	rc := newRecordingClicks()
+	p := NewPipeline(rc, 10, 3, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	for _, code := range []string{"a", "b", "c", "d"} {
+		p.Track(storage.Click{ShortURL: code})
+	}
+	waitSaves(t, rc, 1)
+	assert.Equal(t, [][]string{{"a", "b", "c"}}, rc.recorded(), "Only a full batch is saved before the interval")
+
+	cancel()
+	<-done
+	assert.Equal(t, [][]string{{"a", "b", "c"}, {"d"}}, rc.recorded(), "The partial batch must be saved on shutdown")
+}
+
+func TestPipelineFlushesOnInterval(t *testing.T) {
+	rc := newRecordingClicks()
+	p := NewPipeline(rc, 10, 100, 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+
+	p.Track(storage.Click{ShortURL: "a"})
+	waitSaves(t, rc, 1)
+	assert.Equal(t, [][]string{{"a"}}, rc.recorded())
+}
+
+func TestPipelineDrainsOnShutdown(t *testing.T) {
+	rc := newRecordingClicks()
+	p := NewPipeline(rc, 10, 2, time.Hour)
+	for _, code := range []string{"a", "b", "c"} {
+		p.Track(storage.Click{ShortURL: code})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.Run(ctx)
+
+	assert.Equal(t, [][]string{{"a", "b"}, {"c"}}, rc.recorded(), "Buffered clicks must be saved before Run returns")
+	assert.Zero(t, p.Dropped())
+}
+
+func TestPipelineTrackDropsWhenFull(t *testing.T) {
+	rc := newRecordingClicks()
+	p := NewPipeline(rc, 2, 10, time.Hour)
+
+	for _, code := range []string{"a", "b", "c", "d"} {
+		p.Track(storage.Click{ShortURL: code})
+	}
+	assert.Equal(t, int64(2), p.Dropped(), "Track must drop instead of blocking on a full buffer")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.Run(ctx)
+	assert.Equal(t, [][]string{{"a", "b"}}, rc.recorded())
+}
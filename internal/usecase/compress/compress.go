@@ -33,6 +33,17 @@ func (c *GzipWriter) WriteHeader(statusCode int) {
 	c.w.WriteHeader(statusCode)
 }
 
+// Flush pushes the compressed bytes written so far to the client, which lets
+// handlers stream responses.
+func (c *GzipWriter) Flush() {
+	_ = c.zw.Flush()
+	_ = http.NewResponseController(c.w).Flush()
+}
+
+func (c *GzipWriter) Unwrap() http.ResponseWriter {
+	return c.w
+}
+
 func (c *GzipWriter) Close() error {
 	return c.zw.Close()
 }
@@ -0,0 +1,96 @@
+package deleter
+
+import (
+	"context"
+	"github.com/Yasuhiro-gh/url-shortener/internal/logger"
+	"time"
+)
+
+type BatchDeleter interface {
+	DeleteBatch(ctx context.Context, userID int, shortURLs []string) error
+}
+
+type Job struct {
+	UserID    int
+	ShortURLs []string
+}
+
+type Deleter struct {
+	storage       BatchDeleter
+	jobs          chan Job
+	batchSize     int
+	flushInterval time.Duration
+}
+
+func NewDeleter(storage BatchDeleter, queueSize int, batchSize int, flushInterval time.Duration) *Deleter {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if flushInterval <= 0 {
+		flushInterval = time.Second
+	}
+	return &Deleter{
+		storage:       storage,
+		jobs:          make(chan Job, queueSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+	}
+}
+
+func (d *Deleter) Enqueue(ctx context.Context, job Job) error {
+	select {
+	case d.jobs <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Deleter) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.flushInterval)
+	defer ticker.Stop()
+
+	pending := make(map[int][]string)
+	size := 0
+	for {
+		select {
+		case <-ctx.Done():
+			d.drain(pending, size)
+			return
+		case job := <-d.jobs:
+			pending[job.UserID] = append(pending[job.UserID], job.ShortURLs...)
+			size += len(job.ShortURLs)
+			if size >= d.batchSize {
+				size = d.flush(pending)
+			}
+		case <-ticker.C:
+			size = d.flush(pending)
+		}
+	}
+}
+
+func (d *Deleter) drain(pending map[int][]string, size int) {
+	for {
+		select {
+		case job := <-d.jobs:
+			pending[job.UserID] = append(pending[job.UserID], job.ShortURLs...)
+			size += len(job.ShortURLs)
+			if size >= d.batchSize {
+				size = d.flush(pending)
+			}
+		default:
+			d.flush(pending)
+			return
+		}
+	}
+}
+
+func (d *Deleter) flush(pending map[int][]string) int {
+	for userID, shortURLs := range pending {
+		if err := d.storage.DeleteBatch(context.Background(), userID, shortURLs); err != nil {
+			logger.Errorln("deleter", "user", userID, "error", err, "lost", len(shortURLs))
+		}
+		delete(pending, userID)
+	}
+	return 0
+}
@@ -0,0 +1,107 @@
+package deleter
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingStorage struct {
+	mu      sync.Mutex
+	batches map[int][][]string
+	calls   chan struct{}
+}
+
+func newRecordingStorage() *recordingStorage {
+	return &recordingStorage{batches: make(map[int][][]string), calls: make(chan struct{}, 100)}
+}
+
+func (rs *recordingStorage) DeleteBatch(ctx context.Context, userID int, shortURLs []string) error {
+	rs.mu.Lock()
+	rs.batches[userID] = append(rs.batches[userID], shortURLs)
+	rs.mu.Unlock()
+	rs.calls <- struct{}{}
+	return nil
+}
+
+func (rs *recordingStorage) recorded() map[int][][]string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	batches := make(map[int][][]string, len(rs.batches))
+	for userID, b := range rs.batches {
+		batches[userID] = append([][]string(nil), b...)
+	}
+	return batches
+}
+
+func waitCalls(t *testing.T, rs *recordingStorage, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-rs.calls:
+		case <-time.After(time.Second):
+			t.Fatalf("Got %d of %d batch deletes", i, n)
+		}
+	}
+}
+
+func TestDeleterCoalescesJobsPerUser(t *testing.T) {
+	rs := newRecordingStorage()
+	d := NewDeleter(rs, 10, 5, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+
+	require.NoError(t, d.Enqueue(ctx, Job{UserID: 1, ShortURLs: []string{"a", "b"}}))
+	require.NoError(t, d.Enqueue(ctx, Job{UserID: 2, ShortURLs: []string{"c"}}))
+	require.NoError(t, d.Enqueue(ctx, Job{UserID: 1, ShortURLs: []string{"d", "e"}}))
+	waitCalls(t, rs, 2)
+
+	assert.Equal(t, map[int][][]string{1: {{"a", "b", "d", "e"}}, 2: {{"c"}}}, rs.recorded(),
+		"A full batch must be deleted with one call per user")
+	cancel()
+	<-done
+}
+
+func TestDeleterFlushesOnInterval(t *testing.T) {
+	rs := newRecordingStorage()
+	d := NewDeleter(rs, 10, 100, 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	require.NoError(t, d.Enqueue(ctx, Job{UserID: 1, ShortURLs: []string{"a"}}))
+	waitCalls(t, rs, 1)
+	assert.Equal(t, map[int][][]string{1: {{"a"}}}, rs.recorded())
+}
+
+func TestDeleterDrainsOnShutdown(t *testing.T) {
+	rs := newRecordingStorage()
+	d := NewDeleter(rs, 10, 100, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	require.NoError(t, d.Enqueue(ctx, Job{UserID: 1, ShortURLs: []string{"a"}}))
+	require.NoError(t, d.Enqueue(ctx, Job{UserID: 2, ShortURLs: []string{"b"}}))
+	require.NoError(t, d.Enqueue(ctx, Job{UserID: 1, ShortURLs: []string{"c"}}))
+	cancel()
+	d.Run(ctx)
+
+	assert.Equal(t, map[int][][]string{1: {{"a", "c"}}, 2: {{"b"}}}, rs.recorded(),
+		"Queued jobs must be deleted before Run returns")
+}
+
+func TestDeleterEnqueueRespectsContext(t *testing.T) {
+	d := NewDeleter(newRecordingStorage(), 1, 1, time.Hour)
+	require.NoError(t, d.Enqueue(context.Background(), Job{UserID: 1, ShortURLs: []string{"a"}}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, d.Enqueue(ctx, Job{UserID: 1, ShortURLs: []string{"b"}}), context.DeadlineExceeded,
+		"A full queue must not block past the request")
+}
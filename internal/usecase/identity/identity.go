@@ -0,0 +1,93 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const reserveBlock = 100
+
+type UserIDAllocator interface {
+	NextUserID(ctx context.Context) (int, error)
+}
+
+// CounterAllocator hands out user IDs from an in-process counter. When path is
+// set, the counter's high-water mark is persisted a block ahead, so after a
+// restart allocation resumes past every ID that could have been issued.
+type CounterAllocator struct {
+	mu       sync.Mutex
+	path     string
+	next     int
+	reserved int
+}
+
+func NewCounterAllocator(path string, lastUsed int) (*CounterAllocator, error) {
+	ca := &CounterAllocator{path: path, next: lastUsed + 1}
+	if path == "" {
+		return ca, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		persisted, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, err
+		}
+		if persisted >= ca.next {
+			ca.next = persisted
+		}
+	}
+	ca.reserved = ca.next - 1
+	return ca, nil
+}
+
+func (ca *CounterAllocator) NextUserID(ctx context.Context) (int, error) {
+	return ca.Next(ctx)
+}
+
+// Next hands out the next number; the allocator also backs other persisted
+// counters, such as the short code sequence.
+func (ca *CounterAllocator) Next(ctx context.Context) (int, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if ca.path != "" && ca.next > ca.reserved {
+		if err := ca.persist(ca.next + reserveBlock); err != nil {
+			return 0, err
+		}
+		ca.reserved = ca.next + reserveBlock - 1
+	}
+
+	uid := ca.next
+	ca.next++
+	return uid, nil
+}
+
+func (ca *CounterAllocator) persist(next int) error {
+	tmp, err := os.CreateTemp(filepath.Dir(ca.path), filepath.Base(ca.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strconv.Itoa(next)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ca.path)
+}
@@ -0,0 +1,92 @@
+package identity
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCounterAllocatorStartsAfterLastUsed(t *testing.T) {
+	ca, err := NewCounterAllocator("", 41)
+	require.NoError(t, err)
+
+	for want := 42; want < 45; want++ {
+		uid, err := ca.NextUserID(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, want, uid)
+	}
+}
+
+func TestCounterAllocatorConcurrentIDsAreUnique(t *testing.T) {
+	ca, err := NewCounterAllocator(filepath.Join(t.TempDir(), "users"), 0)
+	require.NoError(t, err)
+
+	const workers, perWorker = 8, 50
+	ids := make(chan int, workers*perWorker)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				uid, err := ca.Next(context.Background())
+				assert.NoError(t, err)
+				ids <- uid
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]bool)
+	for uid := range ids {
+		assert.False(t, seen[uid], "ID %d was handed out twice", uid)
+		seen[uid] = true
+	}
+	assert.Len(t, seen, workers*perWorker)
+}
+
+func TestCounterAllocatorResumesAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+
+	ca, err := NewCounterAllocator(path, 0)
+	require.NoError(t, err)
+	issued := 0
+	for i := 0; i < reserveBlock+5; i++ {
+		issued, err = ca.Next(context.Background())
+		require.NoError(t, err)
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "201", string(data), "The high-water mark must be persisted a block ahead")
+
+	restarted, err := NewCounterAllocator(path, 0)
+	require.NoError(t, err)
+	uid, err := restarted.Next(context.Background())
+	require.NoError(t, err)
+	assert.Greater(t, uid, issued, "A restart must never reissue an ID")
+}
+
+func TestCounterAllocatorPrefersHigherLastUsed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	require.NoError(t, os.WriteFile(path, []byte("10\n"), 0o644))
+
+	ca, err := NewCounterAllocator(path, 500)
+	require.NoError(t, err)
+	uid, err := ca.Next(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 501, uid, "IDs already stored must win over a stale counter file")
+}
+
+func TestCounterAllocatorRejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	require.NoError(t, os.WriteFile(path, []byte("not a number"), 0o644))
+
+	_, err := NewCounterAllocator(path, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter allows limit attempts per key in fixed windows. A limit of zero or
+// less allows everything.
+type Limiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	attempts  map[string]*attempts
+	lastSweep time.Time
+}
+
+type attempts struct {
+	count int
+	start time.Time
+}
+
+func NewLimiter(limit int, window time.Duration) *Limiter {
+	return &Limiter{limit: limit, window: window, attempts: make(map[string]*attempts)}
+}
+
+// Allow records an attempt for key. If the key has used up its window it
+// returns false and the time left until the window ends.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	if l.limit <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	a, ok := l.attempts[key]
+	if !ok || now.Sub(a.start) >= l.window {
+		a = &attempts{start: now}
+		l.attempts[key] = a
+	}
+	if a.count >= l.limit {
+		return false, a.start.Add(l.window).Sub(now)
+	}
+	a.count++
+	return true, 0
+}
+
+// Reset forgets the attempts of key.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, key)
+}
+
+// sweep drops finished windows at most once per window, so the map only
+// holds keys seen recently.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = now
+	for key, a := range l.attempts {
+		if now.Sub(a.start) >= l.window {
+			delete(l.attempts, key)
+		}
+	}
+}
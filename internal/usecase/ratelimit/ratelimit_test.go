@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAllowLimitEdge(t *testing.T) {
+	l := NewLimiter(3, time.Minute)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		ok, wait := l.Allow("key", start.Add(time.Duration(i)*time.Second))
+		assert.True(t, ok, "Attempt %d is within the limit", i+1)
+		assert.Zero(t, wait)
+	}
+
+	ok, wait := l.Allow("key", start.Add(10*time.Second))
+	assert.False(t, ok, "The attempt after the limit must be blocked")
+	assert.Equal(t, 50*time.Second, wait, "The wait must run to the end of the window")
+
+	ok, _ = l.Allow("other", start.Add(10*time.Second))
+	assert.True(t, ok, "Keys must be limited independently")
+}
+
+func TestAllowWindowReset(t *testing.T) {
+	l := NewLimiter(1, time.Minute)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ok, _ := l.Allow("key", start)
+	assert.True(t, ok)
+
+	ok, wait := l.Allow("key", start.Add(time.Minute-time.Nanosecond))
+	assert.False(t, ok, "The window is still open just before it ends")
+	assert.Equal(t, time.Nanosecond, wait)
+
+	ok, _ = l.Allow("key", start.Add(time.Minute))
+	assert.True(t, ok, "A new window must start once the old one ends")
+
+	ok, _ = l.Allow("key", start.Add(time.Minute+time.Second))
+	assert.False(t, ok, "The new window counts from its first attempt")
+}
+
+func TestReset(t *testing.T) {
+	l := NewLimiter(1, time.Minute)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l.Allow("key", now)
+	ok, _ := l.Allow("key", now)
+	assert.False(t, ok)
+
+	l.Reset("key")
+	ok, _ = l.Allow("key", now)
+	assert.True(t, ok, "Reset must forget earlier attempts")
+}
+
+func TestAllowWithoutLimit(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, limit := range []int{0, -1} {
+		l := NewLimiter(limit, time.Minute)
+		for i := 0; i < 100; i++ {
+			ok, wait := l.Allow("key", now)
+			assert.True(t, ok, "Limit %d must allow everything", limit)
+			assert.Zero(t, wait)
+		}
+		assert.Empty(t, l.attempts, "Limit %d must not track keys", limit)
+	}
+}
+
+func TestSweepDropsFinishedWindows(t *testing.T) {
+	l := NewLimiter(1, time.Minute)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l.Allow("old", start)
+	l.Allow("recent", start.Add(30*time.Second))
+	l.Allow("new", start.Add(time.Minute))
+
+	assert.NotContains(t, l.attempts, "old", "A finished window must be swept")
+	assert.Contains(t, l.attempts, "recent")
+	assert.Contains(t, l.attempts, "new")
+
+	l.Allow("new", start.Add(time.Minute+30*time.Second))
+	assert.Contains(t, l.attempts, "recent", "Sweeps must not run more than once per window")
+}
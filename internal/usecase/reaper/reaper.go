@@ -0,0 +1,74 @@
+package reaper
+
+import (
+	"context"
+	"github.com/Yasuhiro-gh/url-shortener/internal/logger"
+	"time"
+)
+
+type ExpiredDeleter interface {
+	DeleteExpired(ctx context.Context, now time.Time, limit int) (int, error)
+	PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+// Reaper removes expired links and, unless trashRetention is zero, links
+// deleted longer than trashRetention ago.
+type Reaper struct {
+	storage        ExpiredDeleter
+	interval       time.Duration
+	batchSize      int
+	trashRetention time.Duration
+}
+
+func NewReaper(storage ExpiredDeleter, interval time.Duration, batchSize int, trashRetention time.Duration) *Reaper {
+	return &Reaper{storage: storage, interval: interval, batchSize: batchSize, trashRetention: trashRetention}
+}
+
+func (r *Reaper) Run(ctx context.Context) {
+	if r.interval <= 0 || r.batchSize <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reap(ctx)
+		}
+	}
+}
+
+func (r *Reaper) reap(ctx context.Context) {
+	now := time.Now()
+	r.drain("deleted", func(limit int) (int, error) {
+		return r.storage.DeleteExpired(ctx, now, limit)
+	})
+	if r.trashRetention > 0 {
+		r.drain("purged", func(limit int) (int, error) {
+			return r.storage.PurgeDeleted(ctx, now.Add(-r.trashRetention), limit)
+		})
+	}
+}
+
+// drain calls remove with batchSize until a batch comes back incomplete.
+func (r *Reaper) drain(what string, remove func(limit int) (int, error)) {
+	total := 0
+	for {
+		removed, err := remove(r.batchSize)
+		total += removed
+		if err != nil {
+			logger.Errorln("reaper", "error", err)
+			return
+		}
+		if removed < r.batchSize {
+			break
+		}
+	}
+	if total > 0 {
+		logger.Infoln("reaper", what, total)
+	}
+}
@@ -0,0 +1,121 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStorage hands out the queued results of each method, then zeros.
+type fakeStorage struct {
+	mu        sync.Mutex
+	expired   []int
+	purged    []int
+	expireErr error
+	cutoffs   []time.Time
+	limits    []int
+	calls     chan struct{}
+}
+
+func (fs *fakeStorage) DeleteExpired(ctx context.Context, now time.Time, limit int) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.limits = append(fs.limits, limit)
+	if fs.calls != nil {
+		select {
+		case fs.calls <- struct{}{}:
+		default:
+		}
+	}
+	if fs.expireErr != nil {
+		return 0, fs.expireErr
+	}
+	return pop(&fs.expired), nil
+}
+
+func (fs *fakeStorage) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.cutoffs = append(fs.cutoffs, before)
+	return pop(&fs.purged), nil
+}
+
+func pop(queue *[]int) int {
+	if len(*queue) == 0 {
+		return 0
+	}
+	n := (*queue)[0]
+	*queue = (*queue)[1:]
+	return n
+}
+
+func TestReapDrainsUntilIncompleteBatch(t *testing.T) {
+	fs := &fakeStorage{expired: []int{10, 10, 3}, purged: []int{10, 0}}
+	r := NewReaper(fs, time.Minute, 10, time.Hour)
+
+	before := time.Now()
+	r.reap(context.Background())
+
+	assert.Equal(t, []int{10, 10, 10}, fs.limits, "DeleteExpired must be called until a batch comes back short")
+	assert.Len(t, fs.cutoffs, 2)
+	assert.WithinDuration(t, before.Add(-time.Hour), fs.cutoffs[0], time.Second,
+		"Only links deleted before the retention window may be purged")
+}
+
+func TestReapKeepsTrashWithoutRetention(t *testing.T) {
+	fs := &fakeStorage{}
+	r := NewReaper(fs, time.Minute, 10, 0)
+
+	r.reap(context.Background())
+
+	assert.Len(t, fs.limits, 1)
+	assert.Empty(t, fs.cutoffs, "A zero retention must never purge the trash")
+}
+
+func TestReapStopsOnError(t *testing.T) {
+	fs := &fakeStorage{expired: []int{10, 10}, expireErr: errors.New("db down")}
+	r := NewReaper(fs, time.Minute, 10, 0)
+
+	r.reap(context.Background())
+
+	assert.Len(t, fs.limits, 1, "A failing batch must not be retried in a loop")
+}
+
+func TestRunReapsOnInterval(t *testing.T) {
+	fs := &fakeStorage{calls: make(chan struct{}, 1)}
+	r := NewReaper(fs, 10*time.Millisecond, 10, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-fs.calls:
+	case <-time.After(time.Second):
+		t.Fatal("Reaper did not run on its interval")
+	}
+	cancel()
+	<-done
+}
+
+func TestRunDisabled(t *testing.T) {
+	fs := &fakeStorage{}
+	done := make(chan struct{})
+	go func() {
+		NewReaper(fs, 0, 10, 0).Run(context.Background())
+		NewReaper(fs, time.Millisecond, 0, 0).Run(context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("A zero interval or batch size must disable the reaper")
+	}
+	assert.Empty(t, fs.limits)
+}
@@ -0,0 +1,45 @@
+package shortcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	AliasMinLength = 3
+	AliasMaxLength = 64
+)
+
+var ErrInvalidAlias = errors.New("invalid alias")
+
+var reservedAliases = map[string]struct{}{
+	"api":   {},
+	"debug": {},
+	"ping":  {},
+}
+
+func ValidateAlias(alias string) error {
+	if len(alias) < AliasMinLength || len(alias) > AliasMaxLength {
+		return fmt.Errorf("%w: length must be between %d and %d", ErrInvalidAlias, AliasMinLength, AliasMaxLength)
+	}
+	for _, c := range alias {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("%w: only latin letters, digits, '-' and '_' are allowed", ErrInvalidAlias)
+		}
+	}
+	if IsReserved(alias) {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidAlias, alias)
+	}
+	return nil
+}
+
+// IsReserved reports whether code names a path served by something else, or
+// is a dot segment that clients resolve away before sending the request.
+func IsReserved(code string) bool {
+	if code == "." || code == ".." {
+		return true
+	}
+	_, ok := reservedAliases[strings.ToLower(code)]
+	return ok
+}
@@ -0,0 +1,185 @@
+package shortcode
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	HashGeneratorType    = "hash"
+	CounterGeneratorType = "counter"
+	RandomGeneratorType  = "random"
+)
+
+const Base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// Generator produces a short code for originalURL. attempt is increased by the
+// caller every time the previous code turned out to be taken by another URL.
+// Reserved paths are never produced.
+type Generator interface {
+	Generate(ctx context.Context, originalURL string, attempt int) (string, error)
+}
+
+// Sequence hands out the numbers the counter generator encodes. It must
+// survive restarts and be shared by replicas, or codes get issued twice.
+type Sequence interface {
+	NextCodeNumber(ctx context.Context) (int, error)
+}
+
+type SequenceFunc func(ctx context.Context) (int, error)
+
+func (f SequenceFunc) NextCodeNumber(ctx context.Context) (int, error) {
+	return f(ctx)
+}
+
+// NewGenerator builds a generator; seq is only used by the counter generator.
+func NewGenerator(generatorType string, length int, alphabet string, seq Sequence) (Generator, error) {
+	switch generatorType {
+	case HashGeneratorType, "":
+		return NewHashGenerator(length)
+	case CounterGeneratorType:
+		return NewCounterGenerator(alphabet, seq)
+	case RandomGeneratorType:
+		return NewRandomGenerator(length, alphabet)
+	}
+	return nil, fmt.Errorf("unknown short code generator: %q", generatorType)
+}
+
+type HashGenerator struct {
+	length int
+}
+
+func NewHashGenerator(length int) (*HashGenerator, error) {
+	if length < 1 || length > sha256.Size*2 {
+		return nil, fmt.Errorf("hash short code length must be between 1 and %d", sha256.Size*2)
+	}
+	return &HashGenerator{length: length}, nil
+}
+
+// Generate moves on to the next attempt for reserved codes, which may then
+// repeat the code of that attempt; the caller retries such collisions anyway.
+func (g *HashGenerator) Generate(_ context.Context, originalURL string, attempt int) (string, error) {
+	for ; ; attempt++ {
+		data := originalURL
+		if attempt > 0 {
+			data += "#" + strconv.Itoa(attempt)
+		}
+		code := fmt.Sprintf("%x", sha256.Sum256([]byte(data)))[:g.length]
+		if !IsReserved(code) {
+			return code, nil
+		}
+	}
+}
+
+type CounterGenerator struct {
+	alphabet string
+	seq      Sequence
+}
+
+func NewCounterGenerator(alphabet string, seq Sequence) (*CounterGenerator, error) {
+	if err := ValidateAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	if seq == nil {
+		return nil, errors.New("counter short code generator needs a sequence")
+	}
+	return &CounterGenerator{alphabet: alphabet, seq: seq}, nil
+}
+
+func (g *CounterGenerator) Generate(ctx context.Context, _ string, _ int) (string, error) {
+	for {
+		n, err := g.seq.NextCodeNumber(ctx)
+		if err != nil {
+			return "", err
+		}
+		if code := encodeCounter(g.alphabet, uint64(n)); !IsReserved(code) {
+			return code, nil
+		}
+	}
+}
+
+func encodeCounter(alphabet string, n uint64) string {
+	base := uint64(len(alphabet))
+	code := make([]byte, 0, 11)
+	for n > 0 {
+		code = append(code, alphabet[n%base])
+		n /= base
+	}
+	for i, j := 0, len(code)-1; i < j; i, j = i+1, j-1 {
+		code[i], code[j] = code[j], code[i]
+	}
+	return string(code)
+}
+
+// DecodeCounter is the inverse of the counter encoding, used to seed a
+// sequence past existing codes; ok is false for codes with characters
+// outside the alphabet or numbers that do not fit an int.
+func DecodeCounter(alphabet string, code string) (n int, ok bool) {
+	base := len(alphabet)
+	for _, c := range []byte(code) {
+		digit := strings.IndexByte(alphabet, c)
+		if digit < 0 || n > (math.MaxInt-digit)/base {
+			return 0, false
+		}
+		n = n*base + digit
+	}
+	return n, code != ""
+}
+
+type RandomGenerator struct {
+	length   int
+	alphabet string
+}
+
+func NewRandomGenerator(length int, alphabet string) (*RandomGenerator, error) {
+	if length < 1 {
+		return nil, errors.New("random short code length must be positive")
+	}
+	if err := ValidateAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	return &RandomGenerator{length: length, alphabet: alphabet}, nil
+}
+
+func (g *RandomGenerator) Generate(_ context.Context, _ string, _ int) (string, error) {
+	max := big.NewInt(int64(len(g.alphabet)))
+	code := make([]byte, g.length)
+	for {
+		for i := range code {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			code[i] = g.alphabet[n.Int64()]
+		}
+		if !IsReserved(string(code)) {
+			return string(code), nil
+		}
+	}
+}
+
+// ValidateAlphabet accepts alphabets of distinct URL unreserved characters,
+// A-Z, a-z, 0-9, '-', '.', '_' and '~', so every code is a plain path segment.
+func ValidateAlphabet(alphabet string) error {
+	if len(alphabet) < 2 {
+		return errors.New("short code alphabet must contain at least two characters")
+	}
+	seen := make(map[rune]struct{}, len(alphabet))
+	for _, c := range alphabet {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-._~", c)) {
+			return fmt.Errorf("short code alphabet contains %q, only A-Z, a-z, 0-9, '-', '.', '_' and '~' are allowed", c)
+		}
+		if _, ok := seen[c]; ok {
+			return fmt.Errorf("short code alphabet contains duplicate character %q", c)
+		}
+		seen[c] = struct{}{}
+	}
+	return nil
+}
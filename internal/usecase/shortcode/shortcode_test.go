@@ -0,0 +1,103 @@
+package shortcode
+
+import (
+	"context"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/identity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestShortCodeGenerators(t *testing.T) {
+	tests := []struct {
+		name          string
+		generatorType string
+		length        int
+		alphabet      string
+		expectedLen   int
+		wantErr       bool
+	}{
+		{name: "hash", generatorType: HashGeneratorType, length: 8, expectedLen: 8},
+		{name: "random", generatorType: RandomGeneratorType, length: 6, alphabet: "abc", expectedLen: 6},
+		{name: "counter", generatorType: CounterGeneratorType, alphabet: Base62Alphabet, expectedLen: 1},
+		{name: "unknown", generatorType: "sequence", wantErr: true},
+		{name: "bad alphabet", generatorType: RandomGeneratorType, length: 6, alphabet: "aa", wantErr: true},
+		{name: "unreserved alphabet", generatorType: RandomGeneratorType, length: 6, alphabet: "ab-._~", expectedLen: 6},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counter, err := identity.NewCounterAllocator("", 0)
+			require.NoError(t, err)
+			gen, err := NewGenerator(test.generatorType, test.length, test.alphabet, SequenceFunc(counter.Next))
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			first, err := gen.Generate(context.Background(), "https://yandex.com", 0)
+			require.NoError(t, err)
+			assert.Len(t, first, test.expectedLen)
+			for _, c := range first {
+				if test.alphabet != "" {
+					assert.Contains(t, test.alphabet, string(c))
+				}
+			}
+
+			retry, err := gen.Generate(context.Background(), "https://yandex.com", 1)
+			require.NoError(t, err)
+			if test.generatorType != RandomGeneratorType {
+				assert.NotEqual(t, first, retry, "Retry produced the same short code")
+			}
+		})
+	}
+}
+
+func TestCounterGeneratorSequence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "codes.seq")
+	generate := func() string {
+		counter, err := identity.NewCounterAllocator(path, 0)
+		require.NoError(t, err)
+		gen, err := NewCounterGenerator(Base62Alphabet, SequenceFunc(counter.Next))
+		require.NoError(t, err)
+		code, err := gen.Generate(context.Background(), "https://yandex.com", 0)
+		require.NoError(t, err)
+		return code
+	}
+	assert.NotEqual(t, generate(), generate(), "A restarted counter must not issue a code again")
+
+	reserved, ok := DecodeCounter(Base62Alphabet, "api")
+	require.True(t, ok)
+	next := reserved - 1
+	gen, err := NewCounterGenerator(Base62Alphabet, SequenceFunc(func(context.Context) (int, error) {
+		next++
+		return next, nil
+	}))
+	require.NoError(t, err)
+	code, err := gen.Generate(context.Background(), "https://yandex.com", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "apj", code, "Reserved paths must be skipped")
+}
+
+func TestValidateAlphabet(t *testing.T) {
+	assert.NoError(t, ValidateAlphabet(Base62Alphabet))
+	assert.NoError(t, ValidateAlphabet("01-._~"))
+	for _, alphabet := range []string{"ab/", "ab?", "ab#", "ab%", "ab ", "abé", "a", "abca"} {
+		assert.Error(t, ValidateAlphabet(alphabet), alphabet)
+	}
+}
+
+func TestCounterGeneratorSkipsDotSegments(t *testing.T) {
+	next := 0
+	gen, err := NewCounterGenerator("_.", SequenceFunc(func(context.Context) (int, error) {
+		next++
+		return next, nil
+	}))
+	require.NoError(t, err)
+	for i := 0; i < 6; i++ {
+		code, err := gen.Generate(context.Background(), "https://yandex.com", 0)
+		require.NoError(t, err)
+		assert.NotContains(t, []string{".", ".."}, code)
+	}
+}
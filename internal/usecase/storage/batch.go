@@ -0,0 +1,70 @@
+package storage
+
+import "errors"
+
+// BatchItem is one value stored by SetBatch under the code ShortURL. After the
+// call Err is nil for stored items, a *ConflictError for URLs the user has
+// already shortened and ErrCodeCollision for codes that belong to another URL.
+type BatchItem struct {
+	ShortURL string
+	Store    Store
+	Err      error
+}
+
+type userURL struct {
+	userID      int
+	originalURL string
+}
+
+// SplitBatch resets the items' errors and separates the ones repeating an
+// earlier item of the same batch, by code or by user and URL, from the unique
+// ones that still have to be checked against storage. duplicateOf maps each
+// repeating item to the earlier one. Items reusing the code of an earlier
+// item for another URL are marked with ErrCodeCollision right away and
+// reported by collided; the backend must then store nothing.
+func SplitBatch(items []BatchItem) (unique []int, duplicateOf map[int]int, collided bool) {
+	duplicateOf = make(map[int]int)
+	byCode := make(map[string]int, len(items))
+	byURL := make(map[userURL]int, len(items))
+	for i := range items {
+		items[i].Err = nil
+		item := items[i]
+		if j, ok := byCode[item.ShortURL]; ok {
+			first := items[j]
+			if item.Store.OriginalURL != first.Store.OriginalURL || item.Store.UserID != first.Store.UserID {
+				items[i].Err = ErrCodeCollision
+				collided = true
+				continue
+			}
+			duplicateOf[i] = j
+			continue
+		}
+		if j, ok := byURL[userURL{item.Store.UserID, item.Store.OriginalURL}]; ok {
+			duplicateOf[i] = j
+			continue
+		}
+		byCode[item.ShortURL] = i
+		byURL[userURL{item.Store.UserID, item.Store.OriginalURL}] = i
+		unique = append(unique, i)
+	}
+	return unique, duplicateOf, collided
+}
+
+// ResolveDuplicates sets the errors of repeating items once the unique ones
+// are resolved.
+func ResolveDuplicates(items []BatchItem, duplicateOf map[int]int) {
+	for i, j := range duplicateOf {
+		first := items[j]
+		var conflict *ConflictError
+		switch {
+		case first.Err == nil:
+			existing := first.Store
+			existing.ShortURL = first.ShortURL
+			items[i].Err = &ConflictError{Existing: existing}
+		case errors.As(first.Err, &conflict):
+			items[i].Err = conflict
+		default:
+			items[i].Err = first.Err
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"golang.org/x/sync/singleflight"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loadTimeout bounds a shared load, which does not stop when the request
+// that started it is cancelled.
+const loadTimeout = 5 * time.Second
+
+type Stats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+type load struct{}
+
+type entry struct {
+	key      string
+	store    storage.Store
+	err      error
+	loadedAt time.Time
+}
+
+// Cache is a read-through LRU in front of a storage backend. Lookups of
+// unknown and deleted codes are cached too, so scans for random codes do not
+// reach the backend; backend failures are never cached. Writes going through
+// the cache invalidate the affected codes.
+type Cache struct {
+	storage.URLStorages
+
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	lru     *list.List
+	// loads holds the in-flight load of each key. Invalidation drops it, so
+	// a load that raced with a write does not put the stale value back.
+	loads map[string]*load
+
+	group  singleflight.Group
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	now    func() time.Time
+}
+
+func NewCache(backend storage.URLStorages, size int, ttl time.Duration) *Cache {
+	return &Cache{
+		URLStorages: backend,
+		size:        size,
+		ttl:         ttl,
+		entries:     make(map[string]*list.Element),
+		loads:       make(map[string]*load),
+		lru:         list.New(),
+		now:         time.Now,
+	}
+}
+
+func (c *Cache) Get(ctx context.Context, key string) (storage.Store, error) {
+	if e, ok := c.lookup(key); ok {
+		c.hits.Add(1)
+		return e.store, e.err
+	}
+	c.misses.Add(1)
+
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		if e, ok := c.lookup(key); ok {
+			return e, nil
+		}
+
+		l := &load{}
+		c.mu.Lock()
+		c.loads[key] = l
+		c.mu.Unlock()
+
+		// The load is shared by every waiting request, so it must not fail
+		// because the first one went away.
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+		store, err := c.URLStorages.Get(loadCtx, key)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		current := c.loads[key] == l
+		if current {
+			delete(c.loads, key)
+		}
+		if err != nil && !errors.Is(err, storage.ErrNotFound) && !errors.Is(err, storage.ErrDeleted) {
+			return nil, err
+		}
+		e := &entry{key: key, store: store, err: err, loadedAt: c.now()}
+		if current {
+			c.add(e)
+		}
+		return e, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return storage.Store{}, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return storage.Store{}, res.Err
+		}
+		e := res.Val.(*entry)
+		return e.store, e.err
+	}
+}
+
+func (c *Cache) Set(ctx context.Context, key string, value *storage.Store) error {
+	defer c.invalidate(key)
+	return c.URLStorages.Set(ctx, key, value)
+}
+
+func (c *Cache) SetBatch(ctx context.Context, items []storage.BatchItem) error {
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.ShortURL
+	}
+	defer c.invalidate(keys...)
+	return c.URLStorages.SetBatch(ctx, items)
+}
+
+func (c *Cache) Update(ctx context.Context, key string, value *storage.Store) error {
+	defer c.invalidate(key)
+	return c.URLStorages.Update(ctx, key, value)
+}
+
+func (c *Cache) UseClick(ctx context.Context, key string) (int64, error) {
+	defer c.invalidate(key)
+	return c.URLStorages.UseClick(ctx, key)
+}
+
+func (c *Cache) Delete(ctx context.Context, key string, userID int) error {
+	defer c.invalidate(key)
+	return c.URLStorages.Delete(ctx, key, userID)
+}
+
+func (c *Cache) DeleteBatch(ctx context.Context, userID int, shortURLs []string) error {
+	defer c.invalidate(shortURLs...)
+	return c.URLStorages.DeleteBatch(ctx, userID, shortURLs)
+}
+
+func (c *Cache) Undelete(ctx context.Context, userID int, shortURLs []string) ([]string, error) {
+	defer c.invalidate(shortURLs...)
+	return c.URLStorages.Undelete(ctx, userID, shortURLs)
+}
+
+// DeleteExpired and PurgeDeleted do not learn which codes the backend
+// removed, so they drop every cached code the backend could have removed.
+func (c *Cache) DeleteExpired(ctx context.Context, now time.Time, limit int) (int, error) {
+	defer c.invalidateWhere(func(store storage.Store) bool {
+		return store.IsExpired(now)
+	})
+	return c.URLStorages.DeleteExpired(ctx, now, limit)
+}
+
+func (c *Cache) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	defer c.invalidateWhere(func(store storage.Store) bool {
+		return store.DeletedFlag && store.DeletedAt.Before(before)
+	})
+	return c.URLStorages.PurgeDeleted(ctx, before, limit)
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+	return Stats{Hits: c.hits.Load(), Misses: c.misses.Load(), Entries: entries}
+}
+
+func (c *Cache) lookup(key string) (*entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if c.ttl > 0 && c.now().Sub(e.loadedAt) >= c.ttl {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+func (c *Cache) add(e *entry) {
+	if c.size <= 0 {
+		return
+	}
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
+
+func (c *Cache) invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+		}
+		delete(c.loads, key)
+		c.group.Forget(key)
+	}
+}
+
+func (c *Cache) invalidateWhere(match func(storage.Store) bool) {
+	c.mu.Lock()
+	var keys []string
+	for key, el := range c.entries {
+		if match(el.Value.(*entry).store) {
+			keys = append(keys, key)
+		}
+	}
+	c.mu.Unlock()
+	c.invalidate(keys...)
+}
@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingStorage struct {
+	*storage.URLStorage
+	gets    atomic.Int64
+	release chan struct{}
+	err     error
+}
+
+func (cs *countingStorage) Get(ctx context.Context, key string) (storage.Store, error) {
+	cs.gets.Add(1)
+	if cs.release != nil {
+		<-cs.release
+	}
+	if err := ctx.Err(); err != nil {
+		return storage.Store{}, err
+	}
+	if cs.err != nil {
+		return storage.Store{}, cs.err
+	}
+	return cs.URLStorage.Get(ctx, key)
+}
+
+func TestCacheHitsAndMisses(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{URLStorage: storage.NewURLStorage()}
+	require.NoError(t, backend.URLStorage.Set(ctx, "abc", &storage.Store{OriginalURL: "https://a.example", UserID: 1}))
+	c := NewCache(backend, 10, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		store, err := c.Get(ctx, "abc")
+		require.NoError(t, err)
+		assert.Equal(t, "https://a.example", store.OriginalURL)
+	}
+	for i := 0; i < 3; i++ {
+		_, err := c.Get(ctx, "missing")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	}
+
+	assert.EqualValues(t, 2, backend.gets.Load(), "Known and unknown codes must be loaded once")
+	assert.Equal(t, Stats{Hits: 4, Misses: 2, Entries: 2}, c.Stats())
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{URLStorage: storage.NewURLStorage()}
+	c := NewCache(backend, 10, time.Minute)
+
+	_, err := c.Get(ctx, "abc")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	require.NoError(t, c.Set(ctx, "abc", &storage.Store{OriginalURL: "https://a.example", UserID: 1}))
+	_, err = c.Get(ctx, "abc")
+	require.NoError(t, err, "Set must drop the cached miss")
+
+	require.NoError(t, c.Update(ctx, "abc", &storage.Store{OriginalURL: "https://b.example", UserID: 1}))
+	store, err := c.Get(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://b.example", store.OriginalURL, "Update must drop the cached url")
+
+	require.NoError(t, c.Delete(ctx, "abc", 1))
+	store, err = c.Get(ctx, "abc")
+	require.ErrorIs(t, err, storage.ErrDeleted, "Delete must drop the cached url")
+	assert.True(t, store.DeletedFlag)
+}
+
+func TestCacheEvictionAndTTL(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{URLStorage: storage.NewURLStorage()}
+	c := NewCache(backend, 2, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Get(ctx, "a")
+	c.Get(ctx, "b")
+	c.Get(ctx, "a")
+	c.Get(ctx, "c")
+	assert.Equal(t, 2, c.Stats().Entries)
+	c.Get(ctx, "b")
+	assert.EqualValues(t, 4, backend.gets.Load(), "The least recently used code must be evicted")
+
+	now = now.Add(time.Minute)
+	c.Get(ctx, "b")
+	assert.EqualValues(t, 5, backend.gets.Load(), "Expired entries must be reloaded")
+}
+
+func TestCacheCoalescesConcurrentMisses(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{URLStorage: storage.NewURLStorage(), release: make(chan struct{})}
+	c := NewCache(backend, 10, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = c.Get(ctx, "abc")
+		}()
+	}
+	for backend.gets.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+
+	assert.EqualValues(t, 1, backend.gets.Load())
+}
+
+func TestCacheDoesNotCacheFailures(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{URLStorage: storage.NewURLStorage(), err: errors.New("connection refused")}
+	c := NewCache(backend, 10, time.Minute)
+
+	_, err := c.Get(ctx, "abc")
+	require.EqualError(t, err, "connection refused")
+
+	backend.err = nil
+	_, err = c.Get(ctx, "abc")
+	assert.ErrorIs(t, err, storage.ErrNotFound, "A backend failure must not be cached")
+	assert.EqualValues(t, 2, backend.gets.Load())
+}
+
+func TestCacheLoadOutlivesFirstCaller(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{URLStorage: storage.NewURLStorage(), release: make(chan struct{})}
+	require.NoError(t, backend.URLStorage.Set(ctx, "abc", &storage.Store{OriginalURL: "https://a.example", UserID: 1}))
+	c := NewCache(backend, 10, time.Minute)
+
+	first, cancel := context.WithCancel(ctx)
+	firstErr := make(chan error)
+	go func() {
+		_, err := c.Get(first, "abc")
+		firstErr <- err
+	}()
+	for backend.gets.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var store storage.Store
+	var err error
+	done := make(chan struct{})
+	go func() {
+		store, err = c.Get(ctx, "abc")
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-firstErr, context.Canceled)
+	close(backend.release)
+	<-done
+
+	require.NoError(t, err, "A waiter must not fail because the first caller was cancelled")
+	assert.Equal(t, "https://a.example", store.OriginalURL)
+	assert.EqualValues(t, 1, backend.gets.Load())
+}
+
+func TestCacheInvalidatesPerKey(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{URLStorage: storage.NewURLStorage(), release: make(chan struct{})}
+	c := NewCache(backend, 10, time.Minute)
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = c.Get(ctx, "abc")
+		close(done)
+	}()
+	for backend.gets.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	require.NoError(t, c.Set(ctx, "other", &storage.Store{OriginalURL: "https://b.example", UserID: 1}))
+	close(backend.release)
+	<-done
+
+	_, err := c.Get(ctx, "abc")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.EqualValues(t, 1, backend.gets.Load(), "A write to another code must not discard the load")
+}
+
+func TestCachePurgeDropsEntries(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{URLStorage: storage.NewURLStorage()}
+	c := NewCache(backend, 10, time.Minute)
+
+	require.NoError(t, c.Set(ctx, "abc", &storage.Store{OriginalURL: "https://a.example", UserID: 1}))
+	require.NoError(t, c.Delete(ctx, "abc", 1))
+	_, err := c.Get(ctx, "abc")
+	require.ErrorIs(t, err, storage.ErrDeleted)
+
+	n, err := c.PurgeDeleted(ctx, time.Now().Add(time.Hour), 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	_, err = c.Get(ctx, "abc")
+	assert.ErrorIs(t, err, storage.ErrNotFound, "PurgeDeleted must drop the cached url")
+}
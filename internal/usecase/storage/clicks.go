@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const clickDayLayout = "2006-01-02"
+
+type Click struct {
+	ShortURL  string
+	ClickedAt time.Time
+	Referrer  string
+	UserAgent string
+	IPHash    string
+}
+
+type DailyClicks struct {
+	Date   string `json:"date"`
+	Clicks int    `json:"clicks"`
+}
+
+type ClickStats struct {
+	TotalClicks    int           `json:"total_clicks"`
+	UniqueVisitors int           `json:"unique_visitors"`
+	Daily          []DailyClicks `json:"daily"`
+}
+
+// ClickSummary is what the click storage keeps of the clicks of a short URL.
+type ClickSummary struct {
+	Total    int            `json:"total"`
+	Visitors []string       `json:"visitors,omitempty"`
+	Daily    map[string]int `json:"daily,omitempty"`
+}
+
+type clickCounter struct {
+	total    int
+	visitors map[string]struct{}
+	daily    map[string]int
+}
+
+type ClickStorage struct {
+	mu      sync.RWMutex
+	clicks  map[string]*clickCounter
+	counter ClickCounter
+}
+
+func NewClickStorage() *ClickStorage {
+	return &ClickStorage{clicks: make(map[string]*clickCounter)}
+}
+
+// NewCountingClickStorage also passes the click totals of every saved batch
+// to counter.
+func NewCountingClickStorage(counter ClickCounter) *ClickStorage {
+	cs := NewClickStorage()
+	cs.counter = counter
+	return cs
+}
+
+func (cs *ClickStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	cs.save(clicks)
+	if cs.counter == nil {
+		return nil
+	}
+	return cs.counter.AddClicks(ctx, CountClicks(clicks))
+}
+
+// CountClicks totals the clicks per short URL.
+func CountClicks(clicks []Click) map[string]int {
+	counts := make(map[string]int)
+	for _, click := range clicks {
+		counts[click.ShortURL]++
+	}
+	return counts
+}
+
+// SummarizeClicks sums the clicks up per short URL.
+func SummarizeClicks(clicks []Click) map[string]ClickSummary {
+	counters := make(map[string]*clickCounter)
+	for _, click := range clicks {
+		counter, ok := counters[click.ShortURL]
+		if !ok {
+			counter = newClickCounter()
+			counters[click.ShortURL] = counter
+		}
+		counter.add(click)
+	}
+	summaries := make(map[string]ClickSummary, len(counters))
+	for key, counter := range counters {
+		summaries[key] = counter.summary()
+	}
+	return summaries
+}
+
+func newClickCounter() *clickCounter {
+	return &clickCounter{visitors: make(map[string]struct{}), daily: make(map[string]int)}
+}
+
+func (c *clickCounter) add(click Click) {
+	c.total++
+	c.visitors[click.IPHash] = struct{}{}
+	c.daily[click.ClickedAt.UTC().Format(clickDayLayout)]++
+}
+
+func (c *clickCounter) merge(summary ClickSummary) {
+	c.total += summary.Total
+	for _, visitor := range summary.Visitors {
+		c.visitors[visitor] = struct{}{}
+	}
+	for day, clicks := range summary.Daily {
+		c.daily[day] += clicks
+	}
+}
+
+func (c *clickCounter) summary() ClickSummary {
+	summary := ClickSummary{Total: c.total, Visitors: make([]string, 0, len(c.visitors)), Daily: make(map[string]int, len(c.daily))}
+	for visitor := range c.visitors {
+		summary.Visitors = append(summary.Visitors, visitor)
+	}
+	sort.Strings(summary.Visitors)
+	for day, clicks := range c.daily {
+		summary.Daily[day] = clicks
+	}
+	return summary
+}
+
+func (cs *ClickStorage) save(clicks []Click) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, click := range clicks {
+		cs.counterOf(click.ShortURL).add(click)
+	}
+}
+
+func (cs *ClickStorage) counterOf(shortURL string) *clickCounter {
+	counter, ok := cs.clicks[shortURL]
+	if !ok {
+		counter = newClickCounter()
+		cs.clicks[shortURL] = counter
+	}
+	return counter
+}
+
+// AddSummary adds summed up clicks to those of shortURL.
+func (cs *ClickStorage) AddSummary(shortURL string, summary ClickSummary) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.counterOf(shortURL).merge(summary)
+}
+
+// RangeSummaries calls f for the clicks of every short URL until f returns
+// false.
+func (cs *ClickStorage) RangeSummaries(f func(shortURL string, summary ClickSummary) bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	for key, counter := range cs.clicks {
+		if !f(key, counter.summary()) {
+			return
+		}
+	}
+}
+
+// Forget drops the clicks of the given short URLs.
+func (cs *ClickStorage) Forget(shortURLs ...string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, key := range shortURLs {
+		delete(cs.clicks, key)
+	}
+}
+
+// Len is the number of short URLs with clicks.
+func (cs *ClickStorage) Len() int {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return len(cs.clicks)
+}
+
+func (cs *ClickStorage) GetClickStats(ctx context.Context, shortURL string) (ClickStats, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	stats := ClickStats{Daily: make([]DailyClicks, 0)}
+	counter, ok := cs.clicks[shortURL]
+	if !ok {
+		return stats, nil
+	}
+	stats.TotalClicks = counter.total
+	stats.UniqueVisitors = len(counter.visitors)
+	for day, clicks := range counter.daily {
+		stats.Daily = append(stats.Daily, DailyClicks{Date: day, Clicks: clicks})
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool {
+		return stats.Daily[i].Date < stats.Daily[j].Date
+	})
+	return stats, nil
+}
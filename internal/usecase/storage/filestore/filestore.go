@@ -2,92 +2,530 @@ package filestore
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/Yasuhiro-gh/url-shortener/internal/config"
+	"github.com/Yasuhiro-gh/url-shortener/internal/logger"
 	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"hash/crc32"
+	"io"
 	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
-var IDCounter int
+const (
+	OpCreate  = "create"
+	OpDelete  = "delete"
+	OpUpdate  = "update"
+	OpRestore = "restore"
+	OpUse     = "use"
+	OpPurge   = "purge"
+	OpClicks  = "clicks"
+)
 
-func CreateFileStorage() error {
-	_, err := os.OpenFile(config.Options.FileStoragePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	return err
+func UserIDCounterPath() string {
+	if config.Options.FileStoragePath == "" {
+		return ""
+	}
+	return config.Options.FileStoragePath + ".uid"
 }
 
+// CodeCounterPath is where the counter short code generator keeps its
+// sequence.
+func CodeCounterPath() string {
+	if config.Options.FileStoragePath == "" {
+		return ""
+	}
+	return config.Options.FileStoragePath + ".seq"
+}
+
+// Record is one line of the JSONL log. Records written before operations were
+// introduced have no op and are treated as creations.
 type Record struct {
-	ID          int    `json:"uuid"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
-	UserID      int    `json:"user_id"`
+	ID          int        `json:"uuid"`
+	Op          string     `json:"op,omitempty"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url,omitempty"`
+	UserID      int        `json:"user_id"`
+	IsDeleted   bool       `json:"is_deleted,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	// RedirectType is the HTTP status of the redirect, 0 for the default.
+	RedirectType int              `json:"redirect_type,omitempty"`
+	ForwardQuery bool             `json:"forward_query,omitempty"`
+	ForwardPath  bool             `json:"forward_path,omitempty"`
+	PasswordHash string           `json:"password_hash,omitempty"`
+	MaxClicks    int64            `json:"max_clicks,omitempty"`
+	UsedClicks   int64            `json:"used_clicks,omitempty"`
+	History      []storage.Change `json:"history,omitempty"`
+	// Clicks are the clicks of the short URL summed up by an OpClicks record.
+	Clicks *storage.ClickSummary `json:"clicks,omitempty"`
 }
 
-func MakeRecord(us *storage.Store) error {
-	if config.Options.DatabaseDSN != "" {
-		return nil
+func newRecord(op string, shortURL string, store storage.Store) Record {
+	r := Record{Op: op, ShortURL: shortURL, OriginalURL: store.OriginalURL, UserID: store.UserID, IsDeleted: store.DeletedFlag,
+		RedirectType: store.RedirectType, ForwardQuery: store.ForwardQuery, ForwardPath: store.ForwardPath,
+		PasswordHash: store.PasswordHash, MaxClicks: store.MaxClicks, UsedClicks: store.UsedClicks}
+	if !store.ExpiresAt.IsZero() {
+		expiresAt := store.ExpiresAt
+		r.ExpiresAt = &expiresAt
+	}
+	if !store.CreatedAt.IsZero() {
+		createdAt := store.CreatedAt
+		r.CreatedAt = &createdAt
+	}
+	if !store.DeletedAt.IsZero() {
+		deletedAt := store.DeletedAt
+		r.DeletedAt = &deletedAt
 	}
+	r.History = store.History
+	return r
+}
 
-	r := Record{ID: IDCounter + 1, ShortURL: us.ShortURL, OriginalURL: us.OriginalURL, UserID: us.UserID}
+func (r Record) store() storage.Store {
+	s := storage.Store{OriginalURL: r.OriginalURL, ShortURL: r.ShortURL, UserID: r.UserID, DeletedFlag: r.IsDeleted,
+		RedirectType: r.RedirectType, ForwardQuery: r.ForwardQuery, ForwardPath: r.ForwardPath,
+		PasswordHash: r.PasswordHash, MaxClicks: r.MaxClicks, UsedClicks: r.UsedClicks}
+	if r.ExpiresAt != nil {
+		s.ExpiresAt = *r.ExpiresAt
+	}
+	if r.CreatedAt != nil {
+		s.CreatedAt = *r.CreatedAt
+	}
+	if r.DeletedAt != nil {
+		s.DeletedAt = *r.DeletedAt
+	}
+	s.History = r.History
+	return s
+}
 
+// FileStorage keeps URLs and their clicks in memory and logs every change to
+// a JSONL file, which is replayed by Restore and periodically compacted into
+// a snapshot.
+type FileStorage struct {
+	*storage.URLStorage
+	clicks *storage.ClickStorage
+
+	mu               sync.Mutex
+	path             string
+	writer           *Writer
+	syncPolicy       string
+	syncInterval     time.Duration
+	idCounter        int
+	logRecords       int
+	compactThreshold int
+}
+
+func NewFileStorage(us *storage.URLStorage, path string, compactThreshold int, syncPolicy string, syncInterval time.Duration) *FileStorage {
+	return &FileStorage{
+		URLStorage:       us,
+		clicks:           storage.NewClickStorage(),
+		path:             path,
+		compactThreshold: compactThreshold,
+		syncPolicy:       syncPolicy,
+		syncInterval:     syncInterval,
+	}
+}
+
+// line is the on-disk envelope of a Record: Checksum is the CRC-32 of the
+// exact Record bytes, so torn or corrupted writes are detected on Restore.
+type line struct {
+	Checksum *uint32         `json:"crc"`
+	Record   json.RawMessage `json:"record"`
+}
+
+func encodeRecord(r Record) ([]byte, error) {
 	rm, err := json.Marshal(r)
 	if err != nil {
+		return nil, err
+	}
+	return fmt.Appendf(nil, "{\"crc\":%d,\"record\":%s}\n", crc32.ChecksumIEEE(rm), rm), nil
+}
+
+func decodeRecord(data []byte) (Record, error) {
+	var l line
+	if err := json.Unmarshal(data, &l); err != nil {
+		return Record{}, err
+	}
+
+	var record Record
+	if l.Checksum == nil {
+		return record, json.Unmarshal(data, &record)
+	}
+	if crc32.ChecksumIEEE(l.Record) != *l.Checksum {
+		return Record{}, errors.New("record checksum mismatch")
+	}
+	return record, json.Unmarshal(l.Record, &record)
+}
+
+func (fs *FileStorage) Set(ctx context.Context, key string, value *storage.Store) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.URLStorage.Set(ctx, key, value); err != nil {
 		return err
 	}
+	return fs.appendRecords(newRecord(OpCreate, key, *value))
+}
 
-	file, err := os.OpenFile(config.Options.FileStoragePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0777)
-	if err != nil {
+func (fs *FileStorage) SetBatch(ctx context.Context, items []storage.BatchItem) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.URLStorage.SetBatch(ctx, items); err != nil {
 		return err
 	}
-	defer file.Close()
+	records := make([]Record, 0, len(items))
+	for _, item := range items {
+		switch {
+		case errors.Is(item.Err, storage.ErrCodeCollision):
+			// A collision means nothing was stored.
+			return nil
+		case item.Err == nil:
+			records = append(records, newRecord(OpCreate, item.ShortURL, item.Store))
+		}
+	}
+	return fs.appendRecords(records...)
+}
 
-	_, err = file.Write(rm)
-	if err != nil {
+func (fs *FileStorage) Update(ctx context.Context, key string, value *storage.Store) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.URLStorage.Update(ctx, key, value); err != nil {
 		return err
 	}
+	return fs.appendRecords(newRecord(OpUpdate, key, *value))
+}
 
-	_, err = file.WriteString("\n")
+// UseClick logs every counted redirect so limits survive restarts.
+func (fs *FileStorage) UseClick(ctx context.Context, key string) (int64, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	used, err := fs.URLStorage.UseClick(ctx, key)
 	if err != nil {
+		return used, err
+	}
+	return used, fs.appendRecords(Record{Op: OpUse, ShortURL: key})
+}
+
+// SaveClicks logs each batch summed up per short URL. Clicks of codes removed
+// in the meantime are dropped, so a code used again starts clean.
+func (fs *FileStorage) SaveClicks(ctx context.Context, clicks []storage.Click) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	summaries := storage.SummarizeClicks(clicks)
+	counts := make(map[string]int, len(summaries))
+	records := make([]Record, 0, len(summaries))
+	for key, summary := range summaries {
+		if _, err := fs.URLStorage.Get(ctx, key); errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		fs.clicks.AddSummary(key, summary)
+		counts[key] = summary.Total
+		records = append(records, Record{Op: OpClicks, ShortURL: key, Clicks: &summary})
+	}
+	if err := fs.URLStorage.AddClicks(ctx, counts); err != nil {
 		return err
 	}
+	return fs.appendRecords(records...)
+}
 
-	IDCounter++
-	return nil
+func (fs *FileStorage) GetClickStats(ctx context.Context, shortURL string) (storage.ClickStats, error) {
+	return fs.clicks.GetClickStats(ctx, shortURL)
 }
 
-func Restore(us *storage.URLS) error {
-	if config.Options.DatabaseDSN != "" {
-		return nil
+func (fs *FileStorage) Delete(ctx context.Context, key string, userID int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.URLStorage.Delete(ctx, key, userID); err != nil {
+		return err
 	}
+	return fs.appendRecords(fs.deleteRecord(key, userID))
+}
+
+func (fs *FileStorage) DeleteBatch(ctx context.Context, userID int, shortURLs []string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 
-	err := CreateFileStorage()
+	records := make([]Record, 0, len(shortURLs))
+	for _, key := range shortURLs {
+		if err := fs.URLStorage.Delete(ctx, key, userID); err == nil {
+			records = append(records, fs.deleteRecord(key, userID))
+		}
+	}
+	return fs.appendRecords(records...)
+}
+
+// deleteRecord carries the deletion time, which the trash retention counts
+// from.
+func (fs *FileStorage) deleteRecord(key string, userID int) Record {
+	r := Record{Op: OpDelete, ShortURL: key, UserID: userID}
+	if store, _ := fs.URLStorage.Get(context.Background(), key); !store.DeletedAt.IsZero() {
+		r.DeletedAt = &store.DeletedAt
+	}
+	return r
+}
+
+func (fs *FileStorage) Undelete(ctx context.Context, userID int, shortURLs []string) ([]string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	restored, err := fs.URLStorage.Undelete(ctx, userID, shortURLs)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	records := make([]Record, len(restored))
+	for i, key := range restored {
+		records[i] = Record{Op: OpRestore, ShortURL: key, UserID: userID}
 	}
+	return restored, fs.appendRecords(records...)
+}
+
+// DeleteExpired and PurgeDeleted log purge records, so removed codes do not
+// come back on restart and a code used again replays cleanly. The clicks of
+// removed codes go with them.
+func (fs *FileStorage) DeleteExpired(ctx context.Context, now time.Time, limit int) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	removed, err := fs.URLStorage.RemoveExpired(ctx, now, limit)
+	return len(removed), errors.Join(err, fs.appendPurges(removed))
+}
 
-	data, err := os.ReadFile(config.Options.FileStoragePath)
+func (fs *FileStorage) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	removed, err := fs.URLStorage.RemoveDeleted(ctx, before, limit)
+	return len(removed), errors.Join(err, fs.appendPurges(removed))
+}
+
+func (fs *FileStorage) appendPurges(keys []string) error {
+	fs.clicks.Forget(keys...)
+	records := make([]Record, len(keys))
+	for i, key := range keys {
+		records[i] = Record{Op: OpPurge, ShortURL: key}
+	}
+	return fs.appendRecords(records...)
+}
+
+// Restore replays the log into memory and opens it for appending. A damaged
+// last record, typically left by a crash in the middle of a write, is reported
+// and cut off; damage anywhere else fails the restore.
+func (fs *FileStorage) Restore() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	file, err := os.OpenFile(fs.path, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	var damaged error
+	missingNewline := false
+	for {
+		data, readErr := reader.ReadBytes('\n')
+		if len(data) == 0 && errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return readErr
+		}
 
-	scn := bufio.NewScanner(bytes.NewReader(data))
+		if damaged != nil {
+			return fmt.Errorf("file storage record at offset %d: %w", offset, damaged)
+		}
 
-	for scn.Scan() {
-		line := scn.Text()
-		record := Record{}
-		err := json.Unmarshal([]byte(line), &record)
-		record.ID = IDCounter + 1
+		record, err := decodeRecord(data)
 		if err != nil {
+			damaged = err
+			continue
+		}
+
+		fs.idCounter++
+		fs.logRecords++
+		if err := fs.apply(record); err != nil {
 			return err
 		}
-		IDCounter++
+		offset += int64(len(data))
+		if readErr != nil {
+			missingNewline = true
+		}
+	}
 
-		s := &storage.Store{UserID: record.UserID, ShortURL: record.ShortURL, OriginalURL: record.OriginalURL}
+	if missingNewline {
+		if _, err := file.WriteAt([]byte("\n"), offset); err != nil {
+			return err
+		}
+	}
+	if damaged != nil {
+		logger.Errorln("filestore", "truncated trailing record", "offset", offset, "error", damaged)
+		if err := file.Truncate(offset); err != nil {
+			return err
+		}
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	fs.writer, err = OpenWriter(fs.path, fs.syncPolicy, fs.syncInterval)
+	return err
+}
 
-		err = us.Set(s.ShortURL, s)
+func (fs *FileStorage) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.writer == nil {
+		return nil
+	}
+	return fs.writer.Close()
+}
+
+func (fs *FileStorage) apply(record Record) error {
+	key := path.Base(record.ShortURL)
+	switch record.Op {
+	case OpCreate, "":
+		err := fs.URLStorage.Set(context.Background(), key, ptr(record.store()))
+		switch {
+		case errors.Is(err, storage.ErrConflict):
+			// Logs written before per-user uniqueness may hold the same URL
+			// under several codes; keep all of them reachable.
+			fs.URLStorage.Replace(key, record.store())
+		case errors.Is(err, storage.ErrCodeCollision):
+			// Logs written before removals were logged may reuse a code
+			// whose earlier URL is gone; the later creation wins.
+			fs.URLStorage.Replace(key, record.store())
+			fs.clicks.Forget(key)
+		case err != nil:
+			return err
+		}
+	case OpUpdate:
+		fs.URLStorage.Replace(key, record.store())
+	case OpDelete:
+		_ = fs.URLStorage.Delete(context.Background(), key, record.UserID)
+		if store, err := fs.URLStorage.Get(context.Background(), key); errors.Is(err, storage.ErrDeleted) && record.DeletedAt != nil {
+			store.DeletedAt = *record.DeletedAt
+			fs.URLStorage.Replace(key, store)
+		}
+	case OpRestore:
+		_, _ = fs.URLStorage.Undelete(context.Background(), record.UserID, []string{key})
+	case OpUse:
+		_, _ = fs.URLStorage.UseClick(context.Background(), key)
+	case OpPurge:
+		fs.URLStorage.Remove(key)
+		fs.clicks.Forget(key)
+	case OpClicks:
+		if record.Clicks == nil {
+			return fmt.Errorf("file storage clicks record of %q has no clicks", key)
+		}
+		fs.clicks.AddSummary(key, *record.Clicks)
+		_ = fs.URLStorage.AddClicks(context.Background(), map[string]int{key: record.Clicks.Total})
+	default:
+		return fmt.Errorf("unknown file storage operation %q", record.Op)
+	}
+	return nil
+}
+
+func (fs *FileStorage) appendRecords(records ...Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var data []byte
+	for i, r := range records {
+		r.ID = fs.idCounter + i + 1
+		encoded, err := encodeRecord(r)
 		if err != nil {
 			return err
 		}
+		data = append(data, encoded...)
+	}
+	if err := fs.writer.Append(data); err != nil {
+		return err
+	}
+	fs.idCounter += len(records)
+	fs.logRecords += len(records)
+
+	if fs.logRecords >= fs.compactThreshold && fs.logRecords >= 2*(fs.URLStorage.Len()+fs.clicks.Len()) {
+		return fs.compact()
+	}
+	return nil
+}
+
+// Compact rewrites the log into a snapshot holding one record per stored URL
+// and one per URL with clicks.
+func (fs *FileStorage) Compact() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.compact()
+}
+
+func (fs *FileStorage) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(fs.path), filepath.Base(fs.path)+".compact")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	id := 0
+	var writeErr error
+	write := func(r Record) bool {
+		id++
+		r.ID = id
+		encoded, err := encodeRecord(r)
+		if err != nil {
+			writeErr = err
+			return false
+		}
+		if _, err := w.Write(encoded); err != nil {
+			writeErr = err
+			return false
+		}
+		return true
+	}
+	fs.URLStorage.Range(func(key string, store storage.Store) bool {
+		return write(newRecord(OpCreate, key, store))
+	})
+	if writeErr == nil {
+		fs.clicks.RangeSummaries(func(key string, summary storage.ClickSummary) bool {
+			return write(Record{Op: OpClicks, ShortURL: key, Clicks: &summary})
+		})
+	}
+	if writeErr == nil {
+		writeErr = w.Flush()
 	}
+	if writeErr == nil {
+		writeErr = tmp.Sync()
+	}
+	if err := tmp.Close(); writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+
+	if err := fs.writer.Replace(tmp.Name()); err != nil {
+		return err
+	}
+	fs.idCounter = id
+	fs.logRecords = id
 	return nil
 }
+
+func ptr(s storage.Store) *storage.Store {
+	return &s
+}
@@ -0,0 +1,342 @@
+package filestore
+
+import (
+	"bufio"
+	"context"
+	"github.com/Yasuhiro-gh/url-shortener/internal/usecase/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func countLines(t *testing.T, path string) int {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	lines := 0
+	scn := bufio.NewScanner(file)
+	for scn.Scan() {
+		lines++
+	}
+	return lines
+}
+
+func TestRestoreReplaysDeletions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, fs.Restore())
+	require.NoError(t, fs.Set(context.Background(), "one", &storage.Store{OriginalURL: "https://yandex.com", UserID: 1}))
+	require.NoError(t, fs.Set(context.Background(), "two", &storage.Store{OriginalURL: "https://ya.ru", UserID: 1}))
+	require.NoError(t, fs.Set(context.Background(), "three", &storage.Store{OriginalURL: "https://practicum.yandex.ru", UserID: 2}))
+	require.NoError(t, fs.DeleteBatch(context.Background(), 1, []string{"one", "three"}))
+	require.NoError(t, fs.Delete(context.Background(), "two", 1))
+
+	restored := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, restored.Restore())
+
+	for key, deleted := range map[string]bool{"one": true, "two": true, "three": false} {
+		stored, err := restored.Get(context.Background(), key)
+		if deleted {
+			require.ErrorIs(t, err, storage.ErrDeleted, key)
+		} else {
+			require.NoError(t, err, key)
+		}
+		assert.Equal(t, deleted, stored.DeletedFlag, key)
+	}
+}
+
+func TestRestoreReplaysUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, fs.Restore())
+	require.NoError(t, fs.Set(context.Background(), "one", &storage.Store{OriginalURL: "https://yandex.com", UserID: 1}))
+	require.NoError(t, fs.Update(context.Background(), "one", &storage.Store{OriginalURL: "https://ya.ru", UserID: 1}))
+	require.NoError(t, fs.Close())
+
+	restored := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, restored.Restore())
+	stored, err := restored.Get(context.Background(), "one")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", stored.OriginalURL)
+	require.NoError(t, restored.Compact())
+
+	compacted := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, compacted.Restore())
+	history, err := compacted.GetURLHistory(context.Background(), "one")
+	require.NoError(t, err)
+	require.Len(t, history, 1, "Compaction must keep the history")
+	assert.Equal(t, "https://yandex.com", history[0].OriginalURL)
+}
+
+func TestRestoreReplaysUndeletes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, fs.Restore())
+	require.NoError(t, fs.Set(context.Background(), "one", &storage.Store{OriginalURL: "https://yandex.com", UserID: 1}))
+	require.NoError(t, fs.Set(context.Background(), "two", &storage.Store{OriginalURL: "https://ya.ru", UserID: 1}))
+	require.NoError(t, fs.DeleteBatch(context.Background(), 1, []string{"one", "two"}))
+	deleted, _ := fs.Get(context.Background(), "two")
+	restored, err := fs.Undelete(context.Background(), 1, []string{"one"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"one"}, restored)
+	require.NoError(t, fs.Close())
+
+	replayed := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, replayed.Restore())
+	_, err = replayed.Get(context.Background(), "one")
+	assert.NoError(t, err)
+	stored, err := replayed.Get(context.Background(), "two")
+	require.ErrorIs(t, err, storage.ErrDeleted)
+	assert.True(t, deleted.DeletedAt.Equal(stored.DeletedAt), "The deletion time must survive a restart")
+}
+
+func TestRestoreReplaysUsedClicks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, fs.Restore())
+	require.NoError(t, fs.Set(context.Background(), "once", &storage.Store{OriginalURL: "https://yandex.com", UserID: 1, MaxClicks: 1}))
+	_, err := fs.UseClick(context.Background(), "once")
+	require.NoError(t, err)
+	require.NoError(t, fs.Close())
+
+	replayed := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, replayed.Restore())
+	_, err = replayed.UseClick(context.Background(), "once")
+	assert.ErrorIs(t, err, storage.ErrClickLimit, "A one-time link must stay used after a restart")
+	require.NoError(t, replayed.Compact())
+	require.NoError(t, replayed.Close())
+
+	compacted := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, compacted.Restore())
+	stored, err := compacted.Get(context.Background(), "once")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stored.UsedClicks)
+}
+
+func TestRestoreReplaysRemovals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	ctx := context.Background()
+
+	fs := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, fs.Restore())
+	require.NoError(t, fs.Set(ctx, "code", &storage.Store{OriginalURL: "https://a.example", UserID: 1, ExpiresAt: time.Now().Add(-time.Minute)}))
+	require.NoError(t, fs.Set(ctx, "trash", &storage.Store{OriginalURL: "https://b.example", UserID: 1}))
+	require.NoError(t, fs.Delete(ctx, "trash", 1))
+	removed, err := fs.DeleteExpired(ctx, time.Now(), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	removed, err = fs.PurgeDeleted(ctx, time.Now().Add(time.Minute), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	require.NoError(t, fs.Set(ctx, "code", &storage.Store{OriginalURL: "https://c.example", UserID: 2}))
+	require.NoError(t, fs.Close())
+
+	replayed := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, replayed.Restore())
+	stored, err := replayed.Get(ctx, "code")
+	require.NoError(t, err)
+	assert.Equal(t, "https://c.example", stored.OriginalURL, "A reused code must keep its new URL")
+	assert.Equal(t, 2, stored.UserID)
+	_, err = replayed.Get(ctx, "trash")
+	assert.ErrorIs(t, err, storage.ErrNotFound, "Purged codes must not come back")
+}
+
+func TestRestoreReplaysClicks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	ctx := context.Background()
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	fs := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, fs.Restore())
+	require.NoError(t, fs.Set(ctx, "code", &storage.Store{OriginalURL: "https://a.example", UserID: 1}))
+	require.NoError(t, fs.Set(ctx, "gone", &storage.Store{OriginalURL: "https://b.example", UserID: 1, ExpiresAt: time.Now().Add(-time.Minute)}))
+	require.NoError(t, fs.SaveClicks(ctx, []storage.Click{
+		{ShortURL: "code", ClickedAt: day, IPHash: "a"},
+		{ShortURL: "code", ClickedAt: day.Add(24 * time.Hour), IPHash: "b"},
+		{ShortURL: "gone", ClickedAt: day, IPHash: "a"},
+		{ShortURL: "missing", ClickedAt: day, IPHash: "a"},
+	}))
+	require.NoError(t, fs.SaveClicks(ctx, []storage.Click{{ShortURL: "code", ClickedAt: day, IPHash: "a"}}))
+	_, err := fs.DeleteExpired(ctx, time.Now(), 10)
+	require.NoError(t, err)
+	require.NoError(t, fs.Set(ctx, "gone", &storage.Store{OriginalURL: "https://c.example", UserID: 2}))
+	require.NoError(t, fs.Close())
+
+	for _, compact := range []bool{false, true} {
+		replayed := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+		require.NoError(t, replayed.Restore())
+		stats, err := replayed.GetClickStats(ctx, "code")
+		require.NoError(t, err)
+		assert.Equal(t, 3, stats.TotalClicks)
+		assert.Equal(t, 2, stats.UniqueVisitors)
+		assert.Equal(t, []storage.DailyClicks{{Date: "2024-05-01", Clicks: 2}, {Date: "2024-05-02", Clicks: 1}}, stats.Daily)
+		stored, err := replayed.Get(ctx, "code")
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, stored.Clicks)
+		stats, err = replayed.GetClickStats(ctx, "gone")
+		require.NoError(t, err)
+		assert.Zero(t, stats.TotalClicks, "A reused code must not inherit the clicks of the removed one")
+		stats, err = replayed.GetClickStats(ctx, "missing")
+		require.NoError(t, err)
+		assert.Zero(t, stats.TotalClicks)
+
+		if compact {
+			assert.Equal(t, 3, countLines(t, path), "The snapshot must keep the clicks")
+		}
+		require.NoError(t, replayed.Compact())
+		require.NoError(t, replayed.Close())
+	}
+}
+
+func TestSetBatchCodeReusedInBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	ctx := context.Background()
+
+	fs := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, fs.Restore())
+	items := []storage.BatchItem{
+		{ShortURL: "same", Store: storage.Store{OriginalURL: "https://a.example", UserID: 1}},
+		{ShortURL: "same", Store: storage.Store{OriginalURL: "https://b.example", UserID: 1}},
+		{ShortURL: "other", Store: storage.Store{OriginalURL: "https://c.example", UserID: 1}},
+	}
+	require.NoError(t, fs.SetBatch(ctx, items))
+	assert.ErrorIs(t, items[1].Err, storage.ErrCodeCollision)
+	_, err := fs.Get(ctx, "other")
+	assert.ErrorIs(t, err, storage.ErrNotFound, "Nothing may be stored that is not logged")
+
+	items[1].ShortURL = "fresh"
+	require.NoError(t, fs.SetBatch(ctx, items))
+	require.NoError(t, fs.Close())
+
+	replayed := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, replayed.Restore())
+	for _, code := range []string{"same", "fresh", "other"} {
+		_, err := replayed.Get(ctx, code)
+		assert.NoError(t, err, code)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs := NewFileStorage(storage.NewURLStorage(), path, 20, SyncAlways, 0)
+	require.NoError(t, fs.Restore())
+	for i := 0; i < 5; i++ {
+		key := "key" + strconv.Itoa(i)
+		require.NoError(t, fs.Set(context.Background(), key, &storage.Store{OriginalURL: "https://yandex.com/" + key, UserID: 1}))
+	}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, fs.Delete(context.Background(), "key0", 1))
+	}
+
+	assert.Less(t, countLines(t, path), 20, "Log was not compacted")
+	matches, err := filepath.Glob(path + ".compact*")
+	require.NoError(t, err)
+	assert.Empty(t, matches, "Temporary snapshot left behind")
+
+	restored := NewFileStorage(storage.NewURLStorage(), path, 20, SyncAlways, 0)
+	require.NoError(t, restored.Restore())
+	assert.Equal(t, 5, restored.Len())
+	stored, err := restored.Get(context.Background(), "key0")
+	require.ErrorIs(t, err, storage.ErrDeleted)
+	assert.True(t, stored.DeletedFlag)
+}
+
+func TestRestoreLegacyRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	legacy := `{"uuid":1,"short_url":"http://localhost:8080/abcdef12","original_url":"https://yandex.com","user_id":3}` + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(legacy), 0666))
+
+	fs := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, fs.Restore())
+
+	stored, err := fs.Get(context.Background(), "abcdef12")
+	require.NoError(t, err)
+	assert.Equal(t, "https://yandex.com", stored.OriginalURL)
+	assert.Equal(t, 3, stored.UserID)
+}
+
+func TestRestoreTruncatedTrailingRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, fs.Restore())
+	require.NoError(t, fs.Set(context.Background(), "one", &storage.Store{OriginalURL: "https://yandex.com", UserID: 1}))
+	require.NoError(t, fs.Set(context.Background(), "two", &storage.Store{OriginalURL: "https://ya.ru", UserID: 1}))
+	require.NoError(t, fs.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data[:len(data)-10], 0600))
+
+	restored := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, restored.Restore())
+	_, err = restored.Get(context.Background(), "one")
+	assert.NoError(t, err)
+	_, err = restored.Get(context.Background(), "two")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	require.NoError(t, restored.Set(context.Background(), "three", &storage.Store{OriginalURL: "https://practicum.yandex.ru", UserID: 1}))
+	require.NoError(t, restored.Close())
+	assert.Equal(t, 2, countLines(t, path))
+}
+
+func TestRestoreCorruptedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	require.NoError(t, fs.Restore())
+	require.NoError(t, fs.Set(context.Background(), "one", &storage.Store{OriginalURL: "https://yandex.com", UserID: 1}))
+	require.NoError(t, fs.Set(context.Background(), "two", &storage.Store{OriginalURL: "https://ya.ru", UserID: 1}))
+	require.NoError(t, fs.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	corrupted := []byte(strings.Replace(string(data), "yandex.com", "yandex.org", 1))
+	require.NoError(t, os.WriteFile(path, corrupted, 0600))
+
+	restored := NewFileStorage(storage.NewURLStorage(), path, 1000, SyncAlways, 0)
+	assert.Error(t, restored.Restore())
+}
+
+func TestConcurrentAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs := NewFileStorage(storage.NewURLStorage(), path, 100000, SyncInterval, time.Millisecond)
+	require.NoError(t, fs.Restore())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := "key" + strconv.Itoa(i)
+			assert.NoError(t, fs.Set(context.Background(), key, &storage.Store{OriginalURL: "https://yandex.com/" + key, UserID: 1}))
+		}(i)
+	}
+	wg.Wait()
+	require.NoError(t, fs.Close())
+
+	restored := NewFileStorage(storage.NewURLStorage(), path, 100000, SyncNever, 0)
+	require.NoError(t, restored.Restore())
+	assert.Equal(t, 50, restored.Len())
+}
+
+func TestOpenWriterPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	_, err := OpenWriter(path, "sometimes", time.Second)
+	assert.Error(t, err)
+	_, err = OpenWriter(path, SyncInterval, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,143 @@
+package filestore
+
+import (
+	"fmt"
+	"github.com/Yasuhiro-gh/url-shortener/internal/logger"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	SyncAlways   = "always"
+	SyncInterval = "interval"
+	SyncNever    = "never"
+)
+
+// Writer owns the single append handle of the log file. Every Append is one
+// write call, so concurrent appends never interleave.
+type Writer struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	policy string
+	dirty  bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func OpenWriter(path string, policy string, interval time.Duration) (*Writer, error) {
+	switch policy {
+	case SyncAlways, SyncNever:
+	case SyncInterval:
+		if interval <= 0 {
+			return nil, fmt.Errorf("fsync interval must be positive, got %s", interval)
+		}
+	default:
+		return nil, fmt.Errorf("unknown fsync policy %q", policy)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{path: path, file: file, policy: policy}
+	if policy == SyncInterval {
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.syncLoop(interval)
+	}
+	return w, nil
+}
+
+func (w *Writer) Append(data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Write(data); err != nil {
+		return err
+	}
+	if w.policy == SyncAlways {
+		return w.file.Sync()
+	}
+	w.dirty = true
+	return nil
+}
+
+func (w *Writer) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sync()
+}
+
+func (w *Writer) sync() error {
+	if !w.dirty {
+		return nil
+	}
+	w.dirty = false
+	return w.file.Sync()
+}
+
+// Replace atomically renames src over the log file and switches the writer to
+// the new file.
+func (w *Writer) Replace(src string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := os.Rename(src, w.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(w.path)); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	old := w.file
+	w.file = file
+	w.dirty = false
+	return old.Close()
+}
+
+func (w *Writer) Close() error {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+func (w *Writer) syncLoop(interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.Sync(); err != nil {
+				logger.Errorln("filestore", "fsync", err)
+			}
+		}
+	}
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
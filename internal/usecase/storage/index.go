@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+// listChunk is how many codes an unlimited listing copies out of the index
+// at a time.
+const listChunk = 1000
+
+type indexedCode struct {
+	createdAt time.Time
+	code      string
+}
+
+// newIndexedCode drops the monotonic clock reading, so entries compare by
+// wall clock like the cursors decoded from requests.
+func newIndexedCode(code string, createdAt time.Time) indexedCode {
+	return indexedCode{createdAt: createdAt.Round(0), code: code}
+}
+
+func (c indexedCode) equal(o indexedCode) bool {
+	return c.code == o.code && c.createdAt.Equal(o.createdAt)
+}
+
+func (c indexedCode) less(o indexedCode) bool {
+	if !c.createdAt.Equal(o.createdAt) {
+		return c.createdAt.Before(o.createdAt)
+	}
+	return c.code < o.code
+}
+
+// userIndex holds the codes of one user oldest first, so new codes are
+// appended and a listing page is found by binary search.
+type userIndex struct {
+	entries []indexedCode
+}
+
+// search returns the position of the first entry not before c.
+func (ui *userIndex) search(c indexedCode) int {
+	return sort.Search(len(ui.entries), func(i int) bool {
+		return !ui.entries[i].less(c)
+	})
+}
+
+func (ui *userIndex) add(c indexedCode) {
+	i := ui.search(c)
+	if i < len(ui.entries) && ui.entries[i].equal(c) {
+		return
+	}
+	ui.entries = append(ui.entries, indexedCode{})
+	copy(ui.entries[i+1:], ui.entries[i:])
+	ui.entries[i] = c
+}
+
+func (ui *userIndex) remove(c indexedCode) {
+	if ui == nil {
+		return
+	}
+	i := ui.search(c)
+	if i < len(ui.entries) && ui.entries[i].equal(c) {
+		ui.entries = append(ui.entries[:i], ui.entries[i+1:]...)
+	}
+}
+
+// before returns up to n entries newest first, starting right before from,
+// or with the newest entry when from is nil.
+func (ui *userIndex) before(from *indexedCode, n int) []indexedCode {
+	if ui == nil {
+		return nil
+	}
+	end := len(ui.entries)
+	if from != nil {
+		end = ui.search(*from)
+	}
+	start := max(end-n, 0)
+	codes := make([]indexedCode, 0, end-start)
+	for i := end - 1; i >= start; i-- {
+		codes = append(codes, ui.entries[i])
+	}
+	return codes
+}
+
+func (ui *userIndex) codes() []string {
+	if ui == nil {
+		return nil
+	}
+	codes := make([]string, len(ui.entries))
+	for i, c := range ui.entries {
+		codes[i] = c.code
+	}
+	return codes
+}
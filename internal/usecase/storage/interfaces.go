@@ -1,11 +1,44 @@
 package storage
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
+// URLStorages is implemented by every URL backend. Get returns ErrNotFound for
+// unknown codes and the stored value together with ErrDeleted for deleted
+// ones; Set returns ErrConflict when the user has already shortened the URL
+// and ErrCodeCollision when the code belongs to another URL; SetBatch reports
+// the same per item and stores nothing if any code collides; Update and
+// Delete return ErrForbidden for codes owned by someone else, and Update
+// returns ErrConflict when the new URL is already shortened under another
+// code. UseClick atomically counts a redirect and returns the new UsedClicks,
+// or ErrClickLimit once MaxClicks redirects were counted. Undelete returns the
+// codes it restored, skipping the ones that are not deleted or not the
+// user's. Any other error means the backend itself failed.
 type URLStorages interface {
-	Get(string) (Store, bool)
-	GetUserID() int
-	GetUserURLS(ctx context.Context, uid int) ([]Store, error)
-	Set(string, *Store) error
-	Delete(string, int) error
+	Get(ctx context.Context, shortURL string) (Store, error)
+	GetUserID(ctx context.Context) (int, error)
+	GetUserURLS(ctx context.Context, uid int, opts ListOptions) (Page, error)
+	Set(ctx context.Context, shortURL string, value *Store) error
+	SetBatch(ctx context.Context, items []BatchItem) error
+	Update(ctx context.Context, shortURL string, value *Store) error
+	GetURLHistory(ctx context.Context, shortURL string) ([]Change, error)
+	UseClick(ctx context.Context, shortURL string) (int64, error)
+	Delete(ctx context.Context, shortURL string, userID int) error
+	DeleteBatch(ctx context.Context, userID int, shortURLs []string) error
+	Undelete(ctx context.Context, userID int, shortURLs []string) ([]string, error)
+	DeleteExpired(ctx context.Context, now time.Time, limit int) (int, error)
+	PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+// ClickCounter keeps the per-URL click totals of backends whose click
+// storage does not do it itself.
+type ClickCounter interface {
+	AddClicks(ctx context.Context, counts map[string]int) error
+}
+
+type ClickStorages interface {
+	SaveClicks(ctx context.Context, clicks []Click) error
+	GetClickStats(ctx context.Context, shortURL string) (ClickStats, error)
 }
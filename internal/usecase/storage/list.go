@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	SortCreated     = "created"
+	SortClicks      = "clicks"
+	SortOriginalURL = "original_url"
+	SortDeleted     = "deleted"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions selects one page of a user's URLs. URLs are sorted newest first
+// by SortCreated, most clicked first by SortClicks and alphabetically by
+// SortOriginalURL, with the code breaking ties. SortDeleted, most recently
+// deleted first, is meant for Trash listings, which hold deleted URLs only.
+// Cursor is the NextCursor of the previous page. A zero Limit lists
+// everything.
+type ListOptions struct {
+	Limit          int
+	Cursor         string
+	Sort           string
+	Query          string
+	IncludeDeleted bool
+	Trash          bool
+}
+
+type Page struct {
+	URLs       []Store
+	NextCursor string
+}
+
+// Cursor is the position after the last URL of a page.
+type Cursor struct {
+	Sort        string    `json:"o"`
+	CreatedAt   time.Time `json:"c"`
+	Clicks      int64     `json:"n"`
+	OriginalURL string    `json:"u"`
+	DeletedAt   time.Time `json:"d"`
+	ShortURL    string    `json:"s"`
+}
+
+// NewCursor returns the encoded position right after last, which must carry
+// its code in ShortURL.
+func NewCursor(sort string, last Store) string {
+	c := Cursor{Sort: sort, ShortURL: last.ShortURL}
+	switch sort {
+	case SortClicks:
+		c.Clicks = last.Clicks
+	case SortOriginalURL:
+		c.OriginalURL = last.OriginalURL
+	case SortDeleted:
+		c.DeletedAt = last.DeletedAt
+	default:
+		c.CreatedAt = last.CreatedAt
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseCursor decodes opts.Cursor; a missing cursor yields nil.
+func (opts ListOptions) ParseCursor() (*Cursor, error) {
+	if opts.Cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != opts.Sort || c.ShortURL == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// Matches reports whether the URL passes the deleted and query filters.
+func (opts ListOptions) Matches(s Store) bool {
+	switch {
+	case opts.Trash && !s.DeletedFlag, !opts.Trash && s.DeletedFlag && !opts.IncludeDeleted:
+		return false
+	}
+	return opts.Query == "" || strings.Contains(strings.ToLower(s.OriginalURL), strings.ToLower(opts.Query))
+}
+
+// Less reports whether a comes before b in the listing order; both must
+// carry their codes in ShortURL.
+func (opts ListOptions) Less(a, b Store) bool {
+	switch opts.Sort {
+	case SortClicks:
+		if a.Clicks != b.Clicks {
+			return a.Clicks > b.Clicks
+		}
+		return a.ShortURL > b.ShortURL
+	case SortOriginalURL:
+		if a.OriginalURL != b.OriginalURL {
+			return a.OriginalURL < b.OriginalURL
+		}
+		return a.ShortURL < b.ShortURL
+	case SortDeleted:
+		if !a.DeletedAt.Equal(b.DeletedAt) {
+			return a.DeletedAt.After(b.DeletedAt)
+		}
+		return a.ShortURL > b.ShortURL
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.ShortURL > b.ShortURL
+}
+
+// After reports whether s comes after the cursor position.
+func (c *Cursor) After(opts ListOptions, s Store) bool {
+	return opts.Less(Store{ShortURL: c.ShortURL, CreatedAt: c.CreatedAt, Clicks: c.Clicks, OriginalURL: c.OriginalURL, DeletedAt: c.DeletedAt}, s)
+}
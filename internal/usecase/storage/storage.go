@@ -3,60 +3,577 @@ package storage
 import (
 	"context"
 	"errors"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"sync"
+	"time"
 )
 
+const shardsCount = 32
+
+var (
+	ErrNotFound      = errors.New("short url not found")
+	ErrConflict      = errors.New("url already shortened")
+	ErrDeleted       = errors.New("short url deleted")
+	ErrForbidden     = errors.New("short url belongs to another user")
+	ErrCodeCollision = errors.New("short code already used by another url")
+	ErrClickLimit    = errors.New("short url click limit reached")
+)
+
+// ConflictError is returned by Set when the user has already shortened the
+// URL; Existing is the stored value, with ShortURL holding its code.
+type ConflictError struct {
+	Existing Store
+}
+
+func (e *ConflictError) Error() string {
+	return ErrConflict.Error()
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
 type Store struct {
 	OriginalURL string
 	ShortURL    string
-	UserID      int  `json:"-"`
-	DeletedFlag bool `json:"is_deleted"`
+	UserID      int       `json:"-"`
+	DeletedFlag bool      `json:"is_deleted"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	Clicks      int64     `json:"clicks"`
+	DeletedAt   time.Time `json:"deleted_at"`
+	// RedirectType is the HTTP status of the redirect, 0 for the default.
+	RedirectType int `json:"redirect_type"`
+	// ForwardQuery and ForwardPath pass the visitor's query string and the
+	// path after the code on to the destination.
+	ForwardQuery bool `json:"forward_query"`
+	ForwardPath  bool `json:"forward_path"`
+	// PasswordHash is the bcrypt hash visitors must match, empty for links
+	// without a password.
+	PasswordHash string `json:"-"`
+	// MaxClicks limits the redirects of the link, 0 means unlimited.
+	// UsedClicks counts them; unlike Clicks it is updated synchronously by
+	// UseClick.
+	MaxClicks  int64 `json:"max_clicks"`
+	UsedClicks int64 `json:"used_clicks"`
+	// History is only kept by the memory storage; use GetURLHistory.
+	History []Change `json:"-"`
 }
 
-type URLStorage struct {
+// Change records a destination a short URL pointed at until ChangedAt.
+type Change struct {
+	OriginalURL string    `json:"original_url"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
+
+func (s Store) IsExpired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && !s.ExpiresAt.After(now)
+}
+
+// markDeleted keeps the time of the first deletion.
+func (s *Store) markDeleted(now time.Time) {
+	if !s.DeletedFlag {
+		s.DeletedFlag = true
+		s.DeletedAt = now.UTC()
+	}
+}
+
+type shard struct {
+	mu   sync.RWMutex
 	urls map[string]Store
 }
 
+type URLStorage struct {
+	shards []*shard
+
+	usersMu   sync.RWMutex
+	userURLS  map[int]*userIndex
+	originals map[int]map[string]string
+	maxUserID int
+}
+
 func NewURLStorage() *URLStorage {
-	return &URLStorage{urls: make(map[string]Store)}
+	shards := make([]*shard, shardsCount)
+	for i := range shards {
+		shards[i] = &shard{urls: make(map[string]Store)}
+	}
+	return &URLStorage{
+		shards:    shards,
+		userURLS:  make(map[int]*userIndex),
+		originals: make(map[int]map[string]string),
+	}
+}
+
+func (us *URLStorage) getShard(key string) *shard {
+	return us.shards[shardIndex(key)]
+}
+
+func shardIndex(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % shardsCount)
 }
 
-func (us *URLStorage) Get(key string) (Store, bool) {
-	value, ok := us.urls[key]
+func (us *URLStorage) Get(ctx context.Context, key string) (Store, error) {
+	value, ok := us.get(key)
+	switch {
+	case !ok:
+		return Store{}, ErrNotFound
+	case value.DeletedFlag:
+		return value, ErrDeleted
+	}
+	return value, nil
+}
+
+func (us *URLStorage) get(key string) (Store, bool) {
+	s := us.getShard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.urls[key]
 	return value, ok
 }
 
-func (us *URLStorage) GetUserID() int {
-	uid := 0
-	for _, store := range us.urls {
-		if store.UserID > uid {
-			uid = store.UserID
+func (us *URLStorage) GetUserID(ctx context.Context) (int, error) {
+	us.usersMu.RLock()
+	defer us.usersMu.RUnlock()
+	return us.maxUserID, nil
+}
+
+// GetUserURLS lists the user's URLs found through the per-user index, so the
+// cost depends on the user's links only. The index keeps them in creation
+// order, which the default listing pages through directly; the other orders
+// depend on clicks and deletions and are sorted per request.
+func (us *URLStorage) GetUserURLS(ctx context.Context, uid int, opts ListOptions) (Page, error) {
+	cursor, err := opts.ParseCursor()
+	if err != nil {
+		return Page{}, err
+	}
+	if opts.Sort == SortCreated {
+		return us.listCreated(uid, opts, cursor), nil
+	}
+
+	us.usersMu.RLock()
+	keys := us.userURLS[uid].codes()
+	us.usersMu.RUnlock()
+
+	urlStores := make([]Store, 0, len(keys))
+	for _, key := range keys {
+		store, ok := us.get(key)
+		if !ok || store.UserID != uid {
+			continue
+		}
+		store.ShortURL = key
+		if opts.Matches(store) && (cursor == nil || cursor.After(opts, store)) {
+			urlStores = append(urlStores, store)
 		}
 	}
-	return uid
+	sort.Slice(urlStores, func(i, j int) bool {
+		return opts.Less(urlStores[i], urlStores[j])
+	})
+
+	page := Page{URLs: urlStores}
+	if opts.Limit > 0 && len(urlStores) > opts.Limit {
+		page.URLs = urlStores[:opts.Limit]
+		page.NextCursor = NewCursor(opts.Sort, page.URLs[opts.Limit-1])
+	}
+	return page, nil
 }
 
-func (us *URLStorage) GetUserURLS(ctx context.Context, uid int) ([]Store, error) {
-	urlStores := make([]Store, 0)
-	for _, store := range us.urls {
-		if store.UserID == uid {
-			urlStores = append(urlStores, store)
+// AddClicks bumps the click totals used to sort listings.
+func (us *URLStorage) AddClicks(ctx context.Context, counts map[string]int) error {
+	for key, n := range counts {
+		s := us.getShard(key)
+		s.mu.Lock()
+		if store, ok := s.urls[key]; ok {
+			store.Clicks += int64(n)
+			s.urls[key] = store
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (us *URLStorage) UseClick(ctx context.Context, key string) (int64, error) {
+	s := us.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store, ok := s.urls[key]
+	switch {
+	case !ok:
+		return 0, ErrNotFound
+	case store.DeletedFlag:
+		return 0, ErrDeleted
+	case store.MaxClicks > 0 && store.UsedClicks >= store.MaxClicks:
+		return store.UsedClicks, ErrClickLimit
+	}
+	store.UsedClicks++
+	s.urls[key] = store
+	return store.UsedClicks, nil
+}
+
+func (us *URLStorage) Set(ctx context.Context, key string, value *Store) error {
+	code, err := us.set(key, value)
+	if !errors.Is(err, ErrConflict) {
+		return err
+	}
+	existing, _ := us.get(code)
+	existing.ShortURL = code
+	return &ConflictError{Existing: existing}
+}
+
+// set returns ErrConflict together with the code the URL is stored under.
+func (us *URLStorage) set(key string, value *Store) (string, error) {
+	s := us.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, existed := s.urls[key]
+	if existed && (prev.OriginalURL != value.OriginalURL || prev.UserID != value.UserID) {
+		return "", ErrCodeCollision
+	}
+	if existed {
+		return key, ErrConflict
+	}
+
+	us.usersMu.Lock()
+	defer us.usersMu.Unlock()
+	if code, ok := us.originals[value.UserID][value.OriginalURL]; ok {
+		return code, ErrConflict
+	}
+	if value.CreatedAt.IsZero() {
+		value.CreatedAt = time.Now().UTC()
+	}
+	s.urls[key] = *value
+	us.index(key, *value, prev, existed)
+	return key, nil
+}
+
+// SetBatch stores the items atomically: if any code collides nothing is
+// stored and the caller is expected to retry with new codes.
+func (us *URLStorage) SetBatch(ctx context.Context, items []BatchItem) error {
+	unique, duplicateOf, collided := SplitBatch(items)
+
+	locked := make([]bool, shardsCount)
+	for _, item := range items {
+		locked[shardIndex(item.ShortURL)] = true
+	}
+	for i, l := range locked {
+		if l {
+			us.shards[i].mu.Lock()
+		}
+	}
+	us.usersMu.Lock()
+
+	conflicts := make(map[int]string)
+	for _, i := range unique {
+		item := &items[i]
+		prev, existed := us.getShard(item.ShortURL).urls[item.ShortURL]
+		switch {
+		case existed && (prev.OriginalURL != item.Store.OriginalURL || prev.UserID != item.Store.UserID):
+			item.Err = ErrCodeCollision
+			collided = true
+		case existed:
+			conflicts[i] = item.ShortURL
+		default:
+			if code, ok := us.originals[item.Store.UserID][item.Store.OriginalURL]; ok {
+				conflicts[i] = code
+			}
+		}
+	}
+	if !collided {
+		now := time.Now().UTC()
+		for _, i := range unique {
+			if _, ok := conflicts[i]; ok {
+				continue
+			}
+			if items[i].Store.CreatedAt.IsZero() {
+				items[i].Store.CreatedAt = now
+			}
+			item := items[i]
+			us.getShard(item.ShortURL).urls[item.ShortURL] = item.Store
+			us.index(item.ShortURL, item.Store, Store{}, false)
+		}
+	}
+
+	us.usersMu.Unlock()
+	for i, l := range locked {
+		if l {
+			us.shards[i].mu.Unlock()
+		}
+	}
+
+	for i, code := range conflicts {
+		existing, _ := us.get(code)
+		existing.ShortURL = code
+		items[i].Err = &ConflictError{Existing: existing}
+	}
+	ResolveDuplicates(items, duplicateOf)
+	return nil
+}
+
+// Update replaces the editable attributes of the URL owned by value.UserID and
+// fills value with the stored result.
+func (us *URLStorage) Update(ctx context.Context, key string, value *Store) error {
+	code, err := us.update(key, value)
+	if !errors.Is(err, ErrConflict) {
+		return err
+	}
+	existing, _ := us.get(code)
+	existing.ShortURL = code
+	return &ConflictError{Existing: existing}
+}
+
+func (us *URLStorage) update(key string, value *Store) (string, error) {
+	s := us.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.urls[key]
+	switch {
+	case !ok:
+		return "", ErrNotFound
+	case prev.UserID != value.UserID:
+		return "", ErrForbidden
+	case prev.DeletedFlag:
+		return "", ErrDeleted
+	}
+
+	us.usersMu.Lock()
+	defer us.usersMu.Unlock()
+	if code, ok := us.originals[value.UserID][value.OriginalURL]; ok && code != key {
+		return code, ErrConflict
+	}
+	updated := *value
+	updated.ShortURL, updated.DeletedFlag, updated.CreatedAt, updated.Clicks = prev.ShortURL, prev.DeletedFlag, prev.CreatedAt, prev.Clicks
+	updated.UsedClicks = prev.UsedClicks
+	updated.History = prev.History
+	if updated.OriginalURL != prev.OriginalURL {
+		updated.History = append(slices.Clip(prev.History), Change{OriginalURL: prev.OriginalURL, ChangedAt: time.Now().UTC()})
+	}
+	s.urls[key] = updated
+	us.index(key, updated, prev, true)
+	*value = updated
+	return key, nil
+}
+
+func (us *URLStorage) GetURLHistory(ctx context.Context, key string) ([]Change, error) {
+	store, _ := us.get(key)
+	return append(make([]Change, 0, len(store.History)), store.History...), nil
+}
+
+// Replace stores value under key unconditionally, even if the key belongs to
+// another URL or user.
+func (us *URLStorage) Replace(key string, value Store) {
+	s := us.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, existed := s.urls[key]
+	s.urls[key] = value
+	us.usersMu.Lock()
+	us.index(key, value, prev, existed)
+	us.usersMu.Unlock()
+}
+
+// listCreated walks the creation order index from the cursor, newest first.
+// Codes are copied out in chunks because shards must not be locked while
+// usersMu is held.
+func (us *URLStorage) listCreated(uid int, opts ListOptions, cursor *Cursor) Page {
+	chunk := opts.Limit + 1
+	if opts.Limit == 0 {
+		chunk = listChunk
+	}
+	var from *indexedCode
+	if cursor != nil {
+		c := newIndexedCode(cursor.ShortURL, cursor.CreatedAt)
+		from = &c
+	}
+
+	var urlStores []Store
+	for opts.Limit == 0 || len(urlStores) <= opts.Limit {
+		us.usersMu.RLock()
+		codes := us.userURLS[uid].before(from, chunk)
+		us.usersMu.RUnlock()
+		if len(codes) == 0 {
+			break
 		}
+		for _, c := range codes {
+			store, ok := us.get(c.code)
+			if !ok || store.UserID != uid {
+				continue
+			}
+			store.ShortURL = c.code
+			if opts.Matches(store) {
+				urlStores = append(urlStores, store)
+			}
+		}
+		from = &codes[len(codes)-1]
+	}
+
+	page := Page{URLs: urlStores}
+	if opts.Limit > 0 && len(urlStores) > opts.Limit {
+		page.URLs = urlStores[:opts.Limit]
+		page.NextCursor = NewCursor(opts.Sort, page.URLs[opts.Limit-1])
+	}
+	return page
+}
+
+// index must be called with both the key's shard and usersMu locked.
+func (us *URLStorage) index(key string, value Store, prev Store, existed bool) {
+	if existed {
+		us.unindex(key, prev)
+	}
+	if us.userURLS[value.UserID] == nil {
+		us.userURLS[value.UserID] = &userIndex{}
+		us.originals[value.UserID] = make(map[string]string)
+	}
+	us.userURLS[value.UserID].add(newIndexedCode(key, value.CreatedAt))
+	us.originals[value.UserID][value.OriginalURL] = key
+	if value.UserID > us.maxUserID {
+		us.maxUserID = value.UserID
 	}
-	return urlStores, nil
 }
 
-func (us *URLStorage) Set(key string, value *Store) error {
-	us.urls[key] = *value
+func (us *URLStorage) unindex(key string, store Store) {
+	us.userURLS[store.UserID].remove(newIndexedCode(key, store.CreatedAt))
+	if us.originals[store.UserID][store.OriginalURL] == key {
+		delete(us.originals[store.UserID], store.OriginalURL)
+	}
+}
+
+func (us *URLStorage) Delete(ctx context.Context, key string, userID int) error {
+	s := us.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	url, ok := s.urls[key]
+	switch {
+	case !ok:
+		return ErrNotFound
+	case url.UserID != userID:
+		return ErrForbidden
+	}
+	url.markDeleted(time.Now())
+	s.urls[key] = url
 	return nil
 }
 
-func (us *URLStorage) Delete(key string, userID int) error {
-	if url, ok := us.urls[key]; ok && url.UserID == userID {
-		url.DeletedFlag = true
-		us.urls[key] = url
-		return nil
+func (us *URLStorage) DeleteBatch(ctx context.Context, userID int, shortURLs []string) error {
+	now := time.Now()
+	for _, key := range shortURLs {
+		s := us.getShard(key)
+		s.mu.Lock()
+		if url, ok := s.urls[key]; ok && url.UserID == userID {
+			url.markDeleted(now)
+			s.urls[key] = url
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (us *URLStorage) Undelete(ctx context.Context, userID int, shortURLs []string) ([]string, error) {
+	restored := make([]string, 0, len(shortURLs))
+	for _, key := range shortURLs {
+		s := us.getShard(key)
+		s.mu.Lock()
+		if url, ok := s.urls[key]; ok && url.UserID == userID && url.DeletedFlag {
+			url.DeletedFlag, url.DeletedAt = false, time.Time{}
+			s.urls[key] = url
+			restored = append(restored, key)
+		}
+		s.mu.Unlock()
+	}
+	return restored, nil
+}
+
+func (us *URLStorage) DeleteExpired(ctx context.Context, now time.Time, limit int) (int, error) {
+	removed, err := us.RemoveExpired(ctx, now, limit)
+	return len(removed), err
+}
+
+func (us *URLStorage) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	removed, err := us.RemoveDeleted(ctx, before, limit)
+	return len(removed), err
+}
+
+// RemoveExpired is DeleteExpired returning the removed codes.
+func (us *URLStorage) RemoveExpired(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	return us.removeWhere(ctx, limit, func(store Store) bool {
+		return store.IsExpired(now)
+	})
+}
+
+// RemoveDeleted is PurgeDeleted returning the removed codes.
+func (us *URLStorage) RemoveDeleted(ctx context.Context, before time.Time, limit int) ([]string, error) {
+	return us.removeWhere(ctx, limit, func(store Store) bool {
+		return store.DeletedFlag && store.DeletedAt.Before(before)
+	})
+}
+
+// Remove drops key whatever its state.
+func (us *URLStorage) Remove(key string) {
+	s := us.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if store, ok := s.urls[key]; ok {
+		delete(s.urls, key)
+		us.usersMu.Lock()
+		us.unindex(key, store)
+		us.usersMu.Unlock()
+	}
+}
+
+// removeWhere drops up to limit URLs for which match returns true.
+func (us *URLStorage) removeWhere(ctx context.Context, limit int, match func(Store) bool) ([]string, error) {
+	var removed []string
+	for _, s := range us.shards {
+		if len(removed) >= limit {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		s.mu.Lock()
+		for key, store := range s.urls {
+			if len(removed) >= limit {
+				break
+			}
+			if !match(store) {
+				continue
+			}
+			delete(s.urls, key)
+			us.usersMu.Lock()
+			us.unindex(key, store)
+			us.usersMu.Unlock()
+			removed = append(removed, key)
+		}
+		s.mu.Unlock()
+	}
+	return removed, nil
+}
+
+func (us *URLStorage) Len() int {
+	n := 0
+	for _, s := range us.shards {
+		s.mu.RLock()
+		n += len(s.urls)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// Range calls fn for every stored URL until fn returns false. Each shard is
+// locked while it is iterated, so fn must not call back into the storage.
+func (us *URLStorage) Range(fn func(key string, store Store) bool) {
+	for _, s := range us.shards {
+		s.mu.RLock()
+		for key, store := range s.urls {
+			if !fn(key, store) {
+				s.mu.RUnlock()
+				return
+			}
+		}
+		s.mu.RUnlock()
 	}
-	return errors.New("wrong user")
 }
 
 type URLS struct {
@@ -67,23 +584,54 @@ func NewURLS(us URLStorages) *URLS {
 	return &URLS{storage: us}
 }
 
-func (us *URLS) Get(url string) (Store, bool) {
-	value, exist := us.storage.Get(url)
-	return value, exist
+func (us *URLS) Get(ctx context.Context, shortURL string) (Store, error) {
+	return us.storage.Get(ctx, shortURL)
+}
+
+func (us *URLS) GetUserID(ctx context.Context) (int, error) {
+	return us.storage.GetUserID(ctx)
+}
+
+func (us *URLS) GetUserURLS(ctx context.Context, uid int, opts ListOptions) (Page, error) {
+	return us.storage.GetUserURLS(ctx, uid, opts)
+}
+
+func (us *URLS) Set(ctx context.Context, shortURL string, value *Store) error {
+	return us.storage.Set(ctx, shortURL, value)
+}
+
+func (us *URLS) SetBatch(ctx context.Context, items []BatchItem) error {
+	return us.storage.SetBatch(ctx, items)
+}
+
+func (us *URLS) Update(ctx context.Context, shortURL string, value *Store) error {
+	return us.storage.Update(ctx, shortURL, value)
+}
+
+func (us *URLS) GetURLHistory(ctx context.Context, shortURL string) ([]Change, error) {
+	return us.storage.GetURLHistory(ctx, shortURL)
+}
+
+func (us *URLS) UseClick(ctx context.Context, shortURL string) (int64, error) {
+	return us.storage.UseClick(ctx, shortURL)
+}
+
+func (us *URLS) Delete(ctx context.Context, shortURL string, userID int) error {
+	return us.storage.Delete(ctx, shortURL, userID)
 }
 
-func (us *URLS) GetUserID() int {
-	return us.storage.GetUserID()
+func (us *URLS) DeleteBatch(ctx context.Context, userID int, shortURLs []string) error {
+	return us.storage.DeleteBatch(ctx, userID, shortURLs)
 }
 
-func (us *URLS) GetUserURLS(ctx context.Context, uid int) ([]Store, error) {
-	return us.storage.GetUserURLS(ctx, uid)
+func (us *URLS) Undelete(ctx context.Context, userID int, shortURLs []string) ([]string, error) {
+	return us.storage.Undelete(ctx, userID, shortURLs)
 }
 
-func (us *URLS) Set(shortURL string, value *Store) error {
-	return us.storage.Set(shortURL, value)
+func (us *URLS) DeleteExpired(ctx context.Context, now time.Time, limit int) (int, error) {
+	return us.storage.DeleteExpired(ctx, now, limit)
 }
 
-func (us *URLS) Delete(shortURL string, userID int) error {
-	return us.storage.Delete(shortURL, userID)
+func (us *URLS) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	return us.storage.PurgeDeleted(ctx, before, limit)
 }
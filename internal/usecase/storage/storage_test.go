@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestURLStorageConcurrentAccess(t *testing.T) {
+	us := NewURLStorage()
+	ctx := context.Background()
+
+	const users = 8
+	const perUser = 200
+
+	var wg sync.WaitGroup
+	for uid := 1; uid <= users; uid++ {
+		wg.Add(1)
+		go func(uid int) {
+			defer wg.Done()
+			for i := 0; i < perUser; i++ {
+				key := strconv.Itoa(uid) + "-" + strconv.Itoa(i)
+				require.NoError(t, us.Set(ctx, key, &Store{OriginalURL: "https://example.com/" + key, ShortURL: key, UserID: uid}))
+				_, err := us.Get(ctx, key)
+				assert.NoError(t, err)
+				if i%2 == 0 {
+					assert.NoError(t, us.Delete(ctx, key, uid))
+				}
+				_, _ = us.GetUserURLS(ctx, uid, ListOptions{Limit: 10})
+			}
+		}(uid)
+	}
+	wg.Wait()
+
+	maxUserID, err := us.GetUserID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, users, maxUserID)
+	for uid := 1; uid <= users; uid++ {
+		page, err := us.GetUserURLS(ctx, uid, ListOptions{IncludeDeleted: true})
+		require.NoError(t, err)
+		assert.Len(t, page.URLs, perUser)
+	}
+}
+
+func TestURLStorageCodeOwnedByAnotherUser(t *testing.T) {
+	us := NewURLStorage()
+	ctx := context.Background()
+	require.NoError(t, us.Set(ctx, "abc", &Store{OriginalURL: "https://a.com", ShortURL: "abc", UserID: 1}))
+	assert.ErrorIs(t, us.Set(ctx, "abc", &Store{OriginalURL: "https://a.com", ShortURL: "abc", UserID: 2}), ErrCodeCollision)
+	assert.ErrorIs(t, us.Set(ctx, "abc", &Store{OriginalURL: "https://b.com", ShortURL: "abc", UserID: 1}), ErrCodeCollision)
+
+	page, err := us.GetUserURLS(ctx, 2, ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, page.URLs)
+
+	assert.ErrorIs(t, us.Delete(ctx, "abc", 2), ErrForbidden)
+	assert.ErrorIs(t, us.Delete(ctx, "xyz", 1), ErrNotFound)
+	assert.NoError(t, us.Delete(ctx, "abc", 1))
+	stored, err := us.Get(ctx, "abc")
+	assert.ErrorIs(t, err, ErrDeleted)
+	assert.True(t, stored.DeletedFlag)
+	assert.ErrorIs(t, us.Set(ctx, "abc", &Store{OriginalURL: "https://a.com", ShortURL: "abc", UserID: 1}), ErrConflict)
+}
+
+func TestURLStorageDeleteExpired(t *testing.T) {
+	us := NewURLStorage()
+	ctx := context.Background()
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		key := "expired" + strconv.Itoa(i)
+		require.NoError(t, us.Set(ctx, key, &Store{OriginalURL: "https://a.com/" + key, ShortURL: key, UserID: 1, ExpiresAt: now.Add(-time.Minute)}))
+	}
+	require.NoError(t, us.Set(ctx, "alive", &Store{OriginalURL: "https://a.com", ShortURL: "alive", UserID: 1, ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, us.Set(ctx, "forever", &Store{OriginalURL: "https://b.com", ShortURL: "forever", UserID: 1}))
+
+	deleted, err := us.DeleteExpired(ctx, now, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+
+	deleted, err = us.DeleteExpired(ctx, now, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	page, err := us.GetUserURLS(ctx, 1, ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, page.URLs, 2)
+}
+
+func TestURLStorageConflict(t *testing.T) {
+	us := NewURLStorage()
+	ctx := context.Background()
+	require.NoError(t, us.Set(ctx, "abc", &Store{OriginalURL: "https://a.com", UserID: 1}))
+	require.NoError(t, us.Set(ctx, "def", &Store{OriginalURL: "https://a.com", UserID: 2}), "Another user may shorten the same url")
+
+	for _, key := range []string{"abc", "xyz"} {
+		err := us.Set(ctx, key, &Store{OriginalURL: "https://a.com", UserID: 1})
+		var conflict *ConflictError
+		require.ErrorAs(t, err, &conflict, key)
+		assert.ErrorIs(t, err, ErrConflict)
+		assert.Equal(t, "abc", conflict.Existing.ShortURL)
+		assert.Equal(t, "https://a.com", conflict.Existing.OriginalURL)
+	}
+	_, err := us.Get(ctx, "xyz")
+	assert.ErrorIs(t, err, ErrNotFound, "A conflicting url must not be stored")
+}
+
+func TestURLStorageSetBatch(t *testing.T) {
+	us := NewURLStorage()
+	ctx := context.Background()
+	require.NoError(t, us.Set(ctx, "abc", &Store{OriginalURL: "https://a.com", UserID: 1}))
+
+	items := []BatchItem{
+		{ShortURL: "new", Store: Store{OriginalURL: "https://b.com", UserID: 1}},
+		{ShortURL: "other", Store: Store{OriginalURL: "https://a.com", UserID: 1}},
+		{ShortURL: "twice", Store: Store{OriginalURL: "https://b.com", UserID: 1}},
+		{ShortURL: "abc", Store: Store{OriginalURL: "https://c.com", UserID: 1}},
+	}
+	require.NoError(t, us.SetBatch(ctx, items))
+	assert.ErrorIs(t, items[3].Err, ErrCodeCollision)
+	_, err := us.Get(ctx, "new")
+	assert.ErrorIs(t, err, ErrNotFound, "A colliding batch must not be stored")
+
+	items[3].ShortURL = "fresh"
+	require.NoError(t, us.SetBatch(ctx, items))
+	assert.NoError(t, items[0].Err)
+	assert.NoError(t, items[3].Err)
+	var conflict *ConflictError
+	require.ErrorAs(t, items[1].Err, &conflict)
+	assert.Equal(t, "abc", conflict.Existing.ShortURL)
+	require.ErrorAs(t, items[2].Err, &conflict)
+	assert.Equal(t, "new", conflict.Existing.ShortURL, "A repeated url must point at the first item")
+
+	page, err := us.GetUserURLS(ctx, 1, ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, page.URLs, 3)
+}
+
+func TestURLStorageSetBatchCodeReusedInBatch(t *testing.T) {
+	us := NewURLStorage()
+	ctx := context.Background()
+
+	items := []BatchItem{
+		{ShortURL: "same", Store: Store{OriginalURL: "https://a.com", UserID: 1}},
+		{ShortURL: "same", Store: Store{OriginalURL: "https://b.com", UserID: 1}},
+		{ShortURL: "other", Store: Store{OriginalURL: "https://c.com", UserID: 1}},
+	}
+	require.NoError(t, us.SetBatch(ctx, items))
+	assert.ErrorIs(t, items[1].Err, ErrCodeCollision)
+	assert.Zero(t, us.Len(), "A batch reusing a code for another url must not be stored")
+}
+
+func TestURLStorageGetUserURLSPages(t *testing.T) {
+	us := NewURLStorage()
+	ctx := context.Background()
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		store := &Store{OriginalURL: "https://example.com/" + strconv.Itoa(5-i), UserID: 1, CreatedAt: created.Add(time.Duration(i) * time.Hour)}
+		require.NoError(t, us.Set(ctx, key, store))
+	}
+	require.NoError(t, us.Set(ctx, "other", &Store{OriginalURL: "https://example.com/1", UserID: 2}))
+	require.NoError(t, us.Set(ctx, "gone", &Store{OriginalURL: "https://example.com/6", UserID: 1, CreatedAt: created}))
+	us.Remove("gone")
+	require.NoError(t, us.AddClicks(ctx, map[string]int{"a": 3, "c": 3, "e": 1}))
+	require.NoError(t, us.Delete(ctx, "b", 1))
+
+	tests := []struct {
+		name string
+		opts ListOptions
+		want []string
+	}{
+		{name: "created", opts: ListOptions{Limit: 2, Sort: SortCreated}, want: []string{"e", "d", "c", "a"}},
+		{name: "created one by one", opts: ListOptions{Limit: 1, Sort: SortCreated}, want: []string{"e", "d", "c", "a"}},
+		{name: "created without limit", opts: ListOptions{Sort: SortCreated}, want: []string{"e", "d", "c", "a"}},
+		{name: "clicks", opts: ListOptions{Limit: 2, Sort: SortClicks}, want: []string{"c", "a", "e", "d"}},
+		{name: "original url", opts: ListOptions{Limit: 3, Sort: SortOriginalURL}, want: []string{"e", "d", "c", "a"}},
+		{name: "include deleted", opts: ListOptions{Limit: 10, Sort: SortCreated, IncludeDeleted: true}, want: []string{"e", "d", "c", "b", "a"}},
+		{name: "query", opts: ListOptions{Limit: 10, Sort: SortCreated, Query: "COM/5"}, want: []string{"a"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			opts := test.opts
+			for {
+				page, err := us.GetUserURLS(ctx, 1, opts)
+				require.NoError(t, err)
+				for _, u := range page.URLs {
+					got = append(got, u.ShortURL)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				opts.Cursor = page.NextCursor
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	_, err := us.GetUserURLS(ctx, 1, ListOptions{Sort: SortClicks, Cursor: NewCursor(SortCreated, Store{ShortURL: "a"})})
+	assert.ErrorIs(t, err, ErrInvalidCursor, "A cursor must not be reused with another sort")
+}
+
+func TestURLStorageUpdate(t *testing.T) {
+	us := NewURLStorage()
+	ctx := context.Background()
+	require.NoError(t, us.Set(ctx, "abc", &Store{OriginalURL: "https://a.com", UserID: 1}))
+	require.NoError(t, us.Set(ctx, "def", &Store{OriginalURL: "https://b.com", UserID: 1}))
+	require.NoError(t, us.Set(ctx, "gone", &Store{OriginalURL: "https://c.com", UserID: 1}))
+	require.NoError(t, us.Delete(ctx, "gone", 1))
+
+	assert.ErrorIs(t, us.Update(ctx, "abc", &Store{OriginalURL: "https://x.com", UserID: 2}), ErrForbidden)
+	assert.ErrorIs(t, us.Update(ctx, "xyz", &Store{OriginalURL: "https://x.com", UserID: 1}), ErrNotFound)
+	assert.ErrorIs(t, us.Update(ctx, "gone", &Store{OriginalURL: "https://x.com", UserID: 1}), ErrDeleted)
+	var conflict *ConflictError
+	require.ErrorAs(t, us.Update(ctx, "abc", &Store{OriginalURL: "https://b.com", UserID: 1}), &conflict)
+	assert.Equal(t, "def", conflict.Existing.ShortURL)
+
+	expiresAt := time.Now().Add(time.Hour)
+	updated := &Store{OriginalURL: "https://x.com", UserID: 1, ExpiresAt: expiresAt}
+	require.NoError(t, us.Update(ctx, "abc", updated))
+	require.NoError(t, us.Update(ctx, "abc", &Store{OriginalURL: "https://y.com", UserID: 1}))
+	assert.False(t, updated.CreatedAt.IsZero(), "Update must keep the creation time")
+
+	stored, err := us.Get(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://y.com", stored.OriginalURL)
+	assert.True(t, stored.ExpiresAt.IsZero())
+	history, err := us.GetURLHistory(ctx, "abc")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "https://a.com", history[0].OriginalURL)
+	assert.Equal(t, "https://x.com", history[1].OriginalURL)
+
+	require.NoError(t, us.Set(ctx, "new", &Store{OriginalURL: "https://a.com", UserID: 1}), "The previous destination must be free again")
+}
+
+func TestURLStorageTrash(t *testing.T) {
+	us := NewURLStorage()
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, us.Set(ctx, key, &Store{OriginalURL: "https://example.com/" + key, UserID: 1}))
+	}
+	require.NoError(t, us.Delete(ctx, "a", 1))
+	require.NoError(t, us.DeleteBatch(ctx, 1, []string{"b"}))
+	deleted, err := us.Get(ctx, "a")
+	require.ErrorIs(t, err, ErrDeleted)
+	require.NoError(t, us.Delete(ctx, "a", 1))
+	again, _ := us.Get(ctx, "a")
+	assert.Equal(t, deleted.DeletedAt, again.DeletedAt, "Deleting twice must keep the first deletion time")
+
+	page, err := us.GetUserURLS(ctx, 1, ListOptions{Sort: SortDeleted, Trash: true})
+	require.NoError(t, err)
+	require.Len(t, page.URLs, 2)
+	assert.Equal(t, "b", page.URLs[0].ShortURL)
+
+	restored, err := us.Undelete(ctx, 2, []string{"a"})
+	require.NoError(t, err)
+	assert.Empty(t, restored, "Only the owner may restore")
+	restored, err = us.Undelete(ctx, 1, []string{"a", "c", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, restored)
+	_, err = us.Get(ctx, "a")
+	assert.NoError(t, err)
+
+	purged, err := us.PurgeDeleted(ctx, time.Now().Add(-time.Hour), 10)
+	require.NoError(t, err)
+	assert.Zero(t, purged, "Recently deleted urls must be kept")
+	purged, err = us.PurgeDeleted(ctx, time.Now().Add(time.Second), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	_, err = us.Get(ctx, "b")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestURLStorageUseClick(t *testing.T) {
+	us := NewURLStorage()
+	ctx := context.Background()
+	require.NoError(t, us.Set(ctx, "once", &Store{OriginalURL: "https://yandex.com", UserID: 1, MaxClicks: 10}))
+
+	var used atomic.Int64
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := us.UseClick(ctx, "once"); err == nil {
+				used.Add(1)
+			} else {
+				assert.ErrorIs(t, err, ErrClickLimit)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(10), used.Load(), "Concurrent redirects must not exceed the limit")
+	stored, err := us.Get(ctx, "once")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), stored.UsedClicks)
+	_, err = us.UseClick(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}